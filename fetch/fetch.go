@@ -22,6 +22,7 @@ import (
 type Script struct {
 	Content string
 	Name    string
+	URL     string // final URL after redirects
 }
 
 // Options configures how a script is fetched.
@@ -32,6 +33,8 @@ type Options struct {
 }
 
 // NewClient creates an HTTP client with system and embedded CA certificates.
+// Like http.DefaultTransport, it uses the proxies named by the HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY environment variables.
 // Debug output is controlled by the logger's debug level.
 func NewClient(logger log.DebugLogger) (*retryablehttp.Client, error) {
 	certPool, err := certs.CertPool(logger)
@@ -44,6 +47,7 @@ func NewClient(logger log.DebugLogger) (*retryablehttp.Client, error) {
 	client.RetryMax = 0 // Unlimited retries
 	client.Logger = nil // Silence debug logging
 	client.HTTPClient.Transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
 		TLSClientConfig: &tls.Config{
 			RootCAs: certPool,
 		},
@@ -114,7 +118,12 @@ func Fetch(ctx context.Context, client *retryablehttp.Client, opts Options, logg
 
 	logger.Debugf("Received script: name=%s, size=%d bytes", name, len(content))
 
-	return Script{Content: content, Name: name}, nil
+	finalURL := opts.URL
+	if resp.Request != nil && resp.Request.URL != nil {
+		finalURL = resp.Request.URL.String()
+	}
+
+	return Script{Content: content, Name: name, URL: finalURL}, nil
 }
 
 func isValidHeaderName(name string) bool {
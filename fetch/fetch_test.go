@@ -5,6 +5,8 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"os/exec"
 	"testing"
 
 	"github.com/installable-sh/lib/log"
@@ -164,3 +166,68 @@ func TestNewClient(t *testing.T) {
 		t.Fatal("NewClient() returned nil client")
 	}
 }
+
+func TestNewClient_Proxy(t *testing.T) {
+	// The proxy settings are read from the environment once per process,
+	// so the client is exercised in a child process.
+	if os.Getenv("FETCH_TEST_PROXY_CHILD") != "" {
+		logger := log.New("test")
+		client, err := NewClient(logger)
+		if err != nil {
+			t.Fatalf("NewClient() error: %v", err)
+		}
+		script, err := Fetch(context.Background(), client, Options{URL: "http://installer.example/install.sh"}, logger)
+		if err != nil {
+			t.Fatalf("Fetch() error: %v", err)
+		}
+		if script.Content != "echo proxied" {
+			t.Fatalf("Fetch() content = %q", script.Content)
+		}
+		return
+	}
+
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		_, _ = w.Write([]byte("echo proxied"))
+	}))
+	defer proxy.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestNewClient_Proxy$")
+	cmd.Env = append(os.Environ(), "FETCH_TEST_PROXY_CHILD=1", "HTTP_PROXY="+proxy.URL, "http_proxy="+proxy.URL, "NO_PROXY=", "no_proxy=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child error: %v\n%s", err, out)
+	}
+	proxy.Close() // waits for the handler
+	if proxied != "http://installer.example/install.sh" {
+		t.Errorf("proxy got %q, want the installer URL", proxied)
+	}
+}
+
+func TestFetch_URLAfterRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/v2/install.sh", http.StatusFound)
+	})
+	mux.HandleFunc("/v2/install.sh", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("echo v2"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	logger := log.New("test")
+	client, err := NewClient(logger)
+	if err != nil {
+		t.Fatalf("NewClient() error: %v", err)
+	}
+
+	script, err := Fetch(context.Background(), client, Options{URL: server.URL + "/latest"}, logger)
+	if err != nil {
+		t.Fatalf("Fetch() error: %v", err)
+	}
+
+	want := server.URL + "/v2/install.sh"
+	if script.URL != want {
+		t.Errorf("Fetch() URL = %q, want %q", script.URL, want)
+	}
+}
@@ -4,11 +4,9 @@ go 1.24.0
 
 require (
 	github.com/hashicorp/go-retryablehttp v0.7.8
+	golang.org/x/sys v0.40.0
+	golang.org/x/term v0.39.0
 	mvdan.cc/sh/v3 v3.12.0
 )
 
-require (
-	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
-	golang.org/x/sys v0.40.0 // indirect
-	golang.org/x/term v0.39.0 // indirect
-)
+require github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
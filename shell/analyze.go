@@ -0,0 +1,437 @@
+package shell
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// FindingKind classifies something noteworthy a script does.
+type FindingKind string
+
+const (
+	FindingSudo        FindingKind = "sudo"
+	FindingEval        FindingKind = "eval"
+	FindingPipeToShell FindingKind = "pipe-to-shell"
+	FindingSystemWrite FindingKind = "system-write"
+	FindingBashism     FindingKind = "bashism"
+)
+
+// Finding is a single observation about a script, tied to a source line.
+type Finding struct {
+	Kind   FindingKind
+	Line   uint
+	Detail string
+}
+
+// Analysis summarizes what a script will likely do when run.
+// It is computed statically and cannot account for dynamic behavior.
+type Analysis struct {
+	// Shell is the interpreter named by the script's shebang, if any.
+	Shell string
+
+	// Commands lists the external commands the script references,
+	// excluding shell builtins and functions it defines.
+	Commands []string
+
+	// URLs lists the URLs that appear in the script's words.
+	URLs []string
+
+	Findings []Finding
+}
+
+// systemPaths are prefixes that installers should not normally write to
+// without the user knowing.
+var systemPaths = []string{
+	"/bin/", "/boot/", "/etc/", "/lib/", "/lib64/", "/opt/",
+	"/sbin/", "/usr/", "/var/", "/Library/", "/System/",
+}
+
+// writingCommands modify the files named in their arguments.
+var writingCommands = map[string]bool{
+	"chmod": true, "chown": true, "cp": true, "dd": true, "install": true,
+	"ln": true, "mkdir": true, "mv": true, "rm": true, "rmdir": true,
+	"tee": true, "touch": true, "unlink": true,
+}
+
+// escalationCommands run their arguments with elevated privileges.
+var escalationCommands = map[string]bool{
+	"sudo": true, "doas": true, "pkexec": true,
+}
+
+// shellCommands interpret their input as a script.
+var shellCommands = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true, "mksh": true,
+}
+
+// downloadCommands fetch remote content.
+var downloadCommands = map[string]bool{
+	"curl": true, "wget": true, "fetch": true,
+}
+
+var urlRegex = regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^\s"'<>|;&()\x60]+`)
+
+// Analyze parses a script and reports what it will likely do when run,
+// without executing anything.
+// Scripts are parsed as bash (or mksh, if declared) so that bashisms in
+// scripts declared POSIX can be reported rather than rejected.
+func Analyze(script Script) (*Analysis, error) {
+	prog, err := parseLenient(script)
+	if err != nil {
+		return nil, err
+	}
+
+	a := newAnalyzer(Shebang(script.Content))
+	a.posix = DetectDialect(script.Content) == DialectPOSIX
+
+	// Collect function names first so calls to them aren't reported as
+	// external commands, regardless of declaration order.
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		if fn, ok := node.(*syntax.FuncDecl); ok {
+			a.funcs[fn.Name.Value] = true
+		}
+		return true
+	})
+	syntax.Walk(prog, a.visit)
+
+	a.analysis.Commands = sortedKeys(a.commands)
+	a.analysis.URLs = sortedKeys(a.urls)
+	slices.SortStableFunc(a.analysis.Findings, func(x, y Finding) int {
+		return int(x.Line) - int(y.Line)
+	})
+	return a.analysis, nil
+}
+
+// parseLenient parses a script as bash, or mksh if declared, so that
+// scripts declared POSIX but using bashisms can still be inspected.
+func parseLenient(script Script) (*syntax.File, error) {
+	lang := syntax.LangBash
+	if DetectDialect(script.Content) == DialectMksh {
+		lang = syntax.LangMirBSDKorn
+	}
+	parser := syntax.NewParser(syntax.Variant(lang))
+	prog, err := parser.Parse(strings.NewReader(script.Content), script.Name)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+	return prog, nil
+}
+
+// Has returns true if the analysis contains a finding of the given kind.
+func (a *Analysis) Has(kind FindingKind) bool {
+	for _, f := range a.Findings {
+		if f.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders a human-readable summary suitable for showing to a user
+// before they confirm running a script.
+func (a *Analysis) String() string {
+	var sb strings.Builder
+	if a.Shell != "" {
+		fmt.Fprintf(&sb, "Shell: %s\n", a.Shell)
+	}
+	if len(a.Commands) > 0 {
+		fmt.Fprintf(&sb, "Commands: %s\n", strings.Join(a.Commands, ", "))
+	}
+	if len(a.URLs) > 0 {
+		sb.WriteString("URLs:\n")
+		for _, u := range a.URLs {
+			fmt.Fprintf(&sb, "  %s\n", u)
+		}
+	}
+	if len(a.Findings) > 0 {
+		sb.WriteString("Findings:\n")
+		for _, f := range a.Findings {
+			fmt.Fprintf(&sb, "  line %d: [%s] %s\n", f.Line, f.Kind, f.Detail)
+		}
+	}
+	return sb.String()
+}
+
+type analyzer struct {
+	analysis *Analysis
+	posix    bool
+	funcs    map[string]bool
+	commands map[string]bool
+	urls     map[string]bool
+	printer  *syntax.Printer
+}
+
+func newAnalyzer(shell string) *analyzer {
+	return &analyzer{
+		analysis: &Analysis{Shell: shell},
+		funcs:    map[string]bool{},
+		commands: map[string]bool{},
+		urls:     map[string]bool{},
+		printer:  syntax.NewPrinter(),
+	}
+}
+
+func (a *analyzer) add(kind FindingKind, pos syntax.Pos, format string, args ...any) {
+	a.analysis.Findings = append(a.analysis.Findings, Finding{
+		Kind:   kind,
+		Line:   pos.Line(),
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+func (a *analyzer) visit(node syntax.Node) bool {
+	switch n := node.(type) {
+	case *syntax.Word:
+		for _, u := range urlRegex.FindAllString(a.print(n), -1) {
+			a.urls[u] = true
+		}
+	case *syntax.CallExpr:
+		a.call(n)
+	case *syntax.BinaryCmd:
+		if n.Op == syntax.Pipe || n.Op == syntax.PipeAll {
+			if a.downloads(n.X) && a.isShell(n.Y) {
+				a.add(FindingPipeToShell, n.OpPos, "downloaded content is piped to a shell")
+			}
+		}
+	case *syntax.Redirect:
+		if n.Word != nil && isWriteRedirect(n.Op) {
+			if lit := leadingLit(n.Word); isSystemPath(lit) {
+				a.add(FindingSystemWrite, n.OpPos, "redirects output to %s", lit)
+			}
+		}
+	}
+	if a.posix {
+		a.bashism(node)
+	}
+	return true
+}
+
+func (a *analyzer) call(call *syntax.CallExpr) {
+	args := call.Args
+	if len(args) == 0 {
+		return
+	}
+	pos := args[0].Pos()
+
+	// Look through privilege escalation to the command being run.
+	if name := args[0].Lit(); escalationCommands[name] {
+		a.commands[name] = true
+		a.add(FindingSudo, pos, "runs %s with elevated privileges", a.print(call))
+		args = skipFlags(args[1:])
+		if len(args) == 0 {
+			return
+		}
+	}
+
+	name := args[0].Lit()
+	if name == "" {
+		return
+	}
+
+	switch {
+	case name == "eval":
+		a.add(FindingEval, pos, "evaluates dynamically built code")
+	case interp.IsBuiltin(name) || a.funcs[name]:
+	default:
+		a.commands[name] = true
+	}
+
+	if name == "eval" || shellCommands[name] {
+		for _, arg := range args[1:] {
+			if a.downloads(arg) {
+				a.add(FindingPipeToShell, pos, "downloaded content is executed by %s", name)
+				break
+			}
+		}
+	}
+
+	if writingCommands[name] {
+		for _, arg := range args[1:] {
+			if lit := leadingLit(arg); isSystemPath(lit) {
+				a.add(FindingSystemWrite, pos, "%s modifies %s", name, lit)
+			}
+		}
+	}
+}
+
+func (a *analyzer) bashism(node syntax.Node) {
+	switch n := node.(type) {
+	case *syntax.CallExpr:
+		if len(n.Args) == 0 {
+			break
+		}
+		switch name := n.Args[0].Lit(); name {
+		case "source":
+			a.add(FindingBashism, n.Pos(), "source is not POSIX; use .")
+		case "[[":
+			// POSIX parsers treat [[ as an ordinary command name.
+			a.add(FindingBashism, n.Pos(), "[[ ]] test clauses are not POSIX")
+		case "[", "test":
+			for _, arg := range n.Args[1:] {
+				if arg.Lit() == "==" {
+					a.add(FindingBashism, arg.Pos(), "== in %s is not POSIX; use =", name)
+					break
+				}
+			}
+		}
+	case *syntax.TestClause:
+		a.add(FindingBashism, n.Pos(), "[[ ]] test clauses are not POSIX")
+	case *syntax.ArithmCmd:
+		a.add(FindingBashism, n.Pos(), "(( )) arithmetic commands are not POSIX")
+	case *syntax.FuncDecl:
+		if n.RsrvWord {
+			a.add(FindingBashism, n.Pos(), "the function keyword is not POSIX")
+		}
+	case *syntax.ProcSubst:
+		a.add(FindingBashism, n.Pos(), "process substitution is not POSIX")
+	case *syntax.ArrayExpr:
+		a.add(FindingBashism, n.Pos(), "arrays are not POSIX")
+	case *syntax.DeclClause:
+		if v := n.Variant.Value; v == "declare" || v == "typeset" || v == "nameref" {
+			a.add(FindingBashism, n.Pos(), "%s is not POSIX", v)
+		}
+	case *syntax.SglQuoted:
+		if n.Dollar {
+			a.add(FindingBashism, n.Pos(), "$'...' quoting is not POSIX")
+		}
+	case *syntax.ParamExp:
+		switch {
+		case n.Slice != nil:
+			a.add(FindingBashism, n.Pos(), "${var:offset} substrings are not POSIX")
+		case n.Repl != nil:
+			a.add(FindingBashism, n.Pos(), "${var/pattern/replacement} is not POSIX")
+		case n.Index != nil:
+			a.add(FindingBashism, n.Pos(), "array indexing is not POSIX")
+		case n.Excl:
+			a.add(FindingBashism, n.Pos(), "${!var} indirection is not POSIX")
+		}
+	case *syntax.Redirect:
+		switch n.Op {
+		case syntax.WordHdoc:
+			a.add(FindingBashism, n.Pos(), "<<< here-strings are not POSIX")
+		case syntax.RdrAll, syntax.AppAll:
+			a.add(FindingBashism, n.Pos(), "&> redirects are not POSIX")
+		}
+	case *syntax.BinaryCmd:
+		if n.Op == syntax.PipeAll {
+			a.add(FindingBashism, n.OpPos, "|& pipes are not POSIX")
+		}
+	case *syntax.CoprocClause:
+		a.add(FindingBashism, n.Pos(), "coprocesses are not POSIX")
+	}
+}
+
+// downloads returns true if node contains a call to a download command.
+func (a *analyzer) downloads(node syntax.Node) bool {
+	found := false
+	syntax.Walk(node, func(n syntax.Node) bool {
+		if call, ok := n.(*syntax.CallExpr); ok && len(call.Args) > 0 {
+			if downloadCommands[call.Args[0].Lit()] {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// isShell returns true if stmt runs a shell interpreter,
+// possibly through privilege escalation.
+func (a *analyzer) isShell(stmt *syntax.Stmt) bool {
+	call, ok := stmt.Cmd.(*syntax.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return false
+	}
+	args := call.Args
+	if escalationCommands[args[0].Lit()] {
+		args = skipFlags(args[1:])
+	}
+	return len(args) > 0 && shellCommands[args[0].Lit()]
+}
+
+func (a *analyzer) print(node syntax.Node) string {
+	var sb strings.Builder
+	if err := a.printer.Print(&sb, node); err != nil {
+		return ""
+	}
+	return sb.String()
+}
+
+// skipFlags drops the options and environment assignments given to sudo
+// or doas, leaving the command being run.
+func skipFlags(args []*syntax.Word) []*syntax.Word {
+	for len(args) > 0 {
+		arg := args[0].Lit()
+		if arg == "--" {
+			args = args[1:]
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		args = args[1:]
+		if escalationValueFlags[arg] && len(args) > 0 {
+			args = args[1:]
+		}
+	}
+	for len(args) > 0 && isAssignment(args[0].Lit()) {
+		args = args[1:]
+	}
+	return args
+}
+
+// leadingLit returns the literal start of a word, including quoted
+// parts, up to its first expansion. For "/etc/$name" it is "/etc/".
+func leadingLit(word *syntax.Word) string {
+	var sb strings.Builder
+	var add func(parts []syntax.WordPart) bool
+	add = func(parts []syntax.WordPart) bool {
+		for _, part := range parts {
+			switch part := part.(type) {
+			case *syntax.Lit:
+				sb.WriteString(part.Value)
+			case *syntax.SglQuoted:
+				sb.WriteString(part.Value)
+			case *syntax.DblQuoted:
+				if !add(part.Parts) {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+		return true
+	}
+	add(word.Parts)
+	return sb.String()
+}
+
+func isSystemPath(p string) bool {
+	for _, prefix := range systemPaths {
+		if strings.HasPrefix(p, prefix) || p == strings.TrimSuffix(prefix, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isWriteRedirect(op syntax.RedirOperator) bool {
+	switch op {
+	case syntax.RdrOut, syntax.AppOut, syntax.RdrAll, syntax.AppAll, syntax.ClbOut, syntax.RdrInOut:
+		return true
+	}
+	return false
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
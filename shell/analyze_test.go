@@ -0,0 +1,143 @@
+package shell
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	script := Script{
+		Name: "install.sh",
+		Content: `#!/bin/sh
+set -e
+setup() {
+	mkdir -p "$HOME/.local/bin"
+}
+setup
+curl -fsSL https://example.com/install.sh | sh
+sudo cp tool /usr/local/bin/tool
+echo 'PATH=x' >> /etc/profile
+eval "$(tool init)"
+if [[ -n "$FOO" ]]; then echo yes; fi
+`,
+	}
+
+	analysis, err := Analyze(script)
+	if err != nil {
+		t.Fatalf("Analyze() error: %v", err)
+	}
+
+	if analysis.Shell != "sh" {
+		t.Errorf("Shell = %q, want %q", analysis.Shell, "sh")
+	}
+
+	wantCommands := []string{"cp", "curl", "mkdir", "sh", "sudo", "tool"}
+	if !slices.Equal(analysis.Commands, wantCommands) {
+		t.Errorf("Commands = %v, want %v", analysis.Commands, wantCommands)
+	}
+
+	wantURLs := []string{"https://example.com/install.sh"}
+	if !slices.Equal(analysis.URLs, wantURLs) {
+		t.Errorf("URLs = %v, want %v", analysis.URLs, wantURLs)
+	}
+
+	for _, kind := range []FindingKind{FindingSudo, FindingEval, FindingPipeToShell, FindingSystemWrite, FindingBashism} {
+		if !analysis.Has(kind) {
+			t.Errorf("Findings should include %s: %v", kind, analysis.Findings)
+		}
+	}
+
+	summary := analysis.String()
+	if !strings.Contains(summary, "line 7: [pipe-to-shell]") {
+		t.Errorf("summary should mention pipe-to-shell on line 7:\n%s", summary)
+	}
+}
+
+func TestAnalyze_SystemWrites(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		wantDetail   string
+		wantCommands []string
+	}{
+		{
+			name:         "quoted copy target",
+			content:      `cp tool "/usr/local/bin/tool"`,
+			wantDetail:   "cp modifies /usr/local/bin/tool",
+			wantCommands: []string{"cp"},
+		},
+		{
+			name:       "quoted redirect",
+			content:    `echo 'PATH=x' >> "/etc/profile"`,
+			wantDetail: "redirects output to /etc/profile",
+		},
+		{
+			name:         "expanded name",
+			content:      `install -m 755 tool '/usr/local/bin/'"$name"`,
+			wantDetail:   "install modifies /usr/local/bin/",
+			wantCommands: []string{"install"},
+		},
+		{
+			name:         "sudo options with values",
+			content:      `sudo -u root FOO=bar cp a /etc/x`,
+			wantDetail:   "cp modifies /etc/x",
+			wantCommands: []string{"cp", "sudo"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis, err := Analyze(Script{Name: "test.sh", Content: tt.content})
+			if err != nil {
+				t.Fatalf("Analyze() error: %v", err)
+			}
+			var details []string
+			for _, f := range analysis.Findings {
+				if f.Kind == FindingSystemWrite {
+					details = append(details, f.Detail)
+				}
+			}
+			if !slices.Equal(details, []string{tt.wantDetail}) {
+				t.Errorf("system writes = %q, want %q", details, tt.wantDetail)
+			}
+			if !slices.Equal(analysis.Commands, tt.wantCommands) {
+				t.Errorf("Commands = %v, want %v", analysis.Commands, tt.wantCommands)
+			}
+		})
+	}
+}
+
+func TestAnalyze_Bashisms(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{name: "posix test", content: "#!/bin/sh\n[ -n \"$A\" ] && echo a", want: false},
+		{name: "double brackets", content: "#!/bin/sh\n[[ -n $A ]]", want: true},
+		{name: "arrays", content: "#!/bin/sh\na=(1 2)", want: true},
+		{name: "here-string", content: "#!/bin/sh\ncat <<< hi", want: true},
+		{name: "substring", content: "#!/bin/sh\necho ${A:1}", want: true},
+		{name: "source", content: "#!/bin/sh\nsource ./lib.sh", want: true},
+		{name: "bash shebang", content: "#!/usr/bin/env bash\n[[ -n $A ]]", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis, err := Analyze(Script{Content: tt.content, Name: "test.sh"})
+			if err != nil {
+				t.Fatalf("Analyze() error: %v", err)
+			}
+			if got := analysis.Has(FindingBashism); got != tt.want {
+				t.Errorf("Has(FindingBashism) = %v, want %v: %v", got, tt.want, analysis.Findings)
+			}
+		})
+	}
+}
+
+func TestAnalyze_ParseError(t *testing.T) {
+	if _, err := Analyze(Script{Content: "if then", Name: "test.sh"}); err == nil {
+		t.Error("Analyze() should fail on syntax errors")
+	}
+}
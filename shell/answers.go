@@ -0,0 +1,281 @@
+package shell
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/installable-sh/lib/log"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// maxPromptLen bounds how much of an unterminated output line is kept as
+// the pending prompt.
+const maxPromptLen = 1024
+
+// Answers scripts responses to interactive prompts, like an answer file.
+// Pass it as Options.Answers; the script's `read` builtin is then fed the
+// response of the first answer whose pattern matches the prompt. The
+// prompt is the -p argument of read, or otherwise the unterminated last
+// line the script wrote to stdout or stderr. External commands read the
+// original stdin.
+type Answers struct {
+	Rules []Answer `json:"answers"`
+
+	// FailOnUnexpected stops the script when it prompts for input that no
+	// answer matches. Otherwise a line of the original stdin is passed
+	// through.
+	FailOnUnexpected bool `json:"fail_on_unexpected,omitempty"`
+
+	mu      sync.Mutex
+	prompts []Prompt
+}
+
+// Answer is a response to prompts matching a regular expression.
+type Answer struct {
+	Prompt   string `json:"prompt"`
+	Response string `json:"response"`
+
+	re *regexp.Regexp
+}
+
+// Prompt records a prompt the script asked.
+type Prompt struct {
+	Text     string
+	Response string
+	Answered bool // false if no answer matched
+	Line     uint
+}
+
+// LoadAnswers reads answers from a JSON answer file.
+func LoadAnswers(path string) (*Answers, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a Answers
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("invalid answers %s: %w", path, err)
+	}
+	for i := range a.Rules {
+		if err := a.Rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("invalid answers %s: %w", path, err)
+		}
+	}
+	return &a, nil
+}
+
+// Add appends an answer for prompts matching the regular expression.
+func (a *Answers) Add(prompt, response string) error {
+	answer := Answer{Prompt: prompt, Response: response}
+	if err := answer.compile(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Rules = append(a.Rules, answer)
+	return nil
+}
+
+// Prompts returns the prompts asked so far, in order.
+func (a *Answers) Prompts() []Prompt {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Prompt(nil), a.prompts...)
+}
+
+func (ans *Answer) compile() error {
+	re, err := regexp.Compile(ans.Prompt)
+	if err != nil {
+		return fmt.Errorf("invalid prompt pattern %q: %w", ans.Prompt, err)
+	}
+	ans.re = re
+	return nil
+}
+
+// match finds the response to a prompt and records it.
+func (a *Answers) match(text string, line uint) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := Prompt{Text: text, Line: line}
+	for i := range a.Rules {
+		rule := &a.Rules[i]
+		if rule.re == nil && rule.compile() != nil {
+			continue
+		}
+		if rule.re.MatchString(text) {
+			p.Response, p.Answered = rule.Response, true
+			break
+		}
+	}
+	a.prompts = append(a.prompts, p)
+	return p.Response, p.Answered
+}
+
+// answerer feeds Answers to a script. The interpreter's stdin is a pipe
+// that answers are written to just before `read` consumes them; external
+// commands are given the original stdin instead.
+type answerer struct {
+	answers *Answers
+	script  *Script
+	logger  log.DebugLogger
+
+	// stdin is the original stdin, which may be nil. Unless it is a file,
+	// it is buffered, and commands read the buffer so nothing is lost.
+	stdin  io.Reader
+	pr, pw *os.File
+
+	mu      sync.Mutex
+	pending string // unterminated last line of output
+	echo    string // read -p prompt about to be written
+}
+
+func newAnswerer(answers *Answers, script *Script, stdin io.Reader, logger log.DebugLogger) (*answerer, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	a := &answerer{answers: answers, script: script, logger: logger, stdin: stdin, pr: pr, pw: pw}
+	if _, ok := stdin.(*os.File); !ok && stdin != nil {
+		a.stdin = bufio.NewReader(stdin)
+	}
+	return a, nil
+}
+
+// readLine reads a line of the original stdin. Files are read a byte at
+// a time, so that commands started later see the rest.
+func (a *answerer) readLine() (string, error) {
+	if r, ok := a.stdin.(*bufio.Reader); ok {
+		return r.ReadString('\n')
+	}
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := a.stdin.Read(b)
+		if n > 0 {
+			line = append(line, b[0])
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// exec gives external commands that would read the answers pipe the
+// original stdin. Those commands are then run by execCommand.
+func (a *answerer) exec(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		if interp.HandlerCtx(ctx).Stdin != io.Reader(a.pr) {
+			return next(ctx, args)
+		}
+		var stdin io.Reader = strings.NewReader("")
+		if a.stdin != nil {
+			stdin = a.stdin
+		}
+		return next(context.WithValue(ctx, stdinKey{}, stdin), args)
+	}
+}
+
+// writer wraps an output stream to track the pending prompt.
+func (a *answerer) writer(w io.Writer) io.Writer {
+	if w == nil {
+		w = io.Discard
+	}
+	return &promptWriter{a: a, w: w}
+}
+
+func (a *answerer) wrote(p []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := string(p)
+	if a.echo != "" && strings.HasPrefix(s, a.echo) {
+		s = s[len(a.echo):]
+		a.echo = ""
+	}
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		a.pending = ""
+		s = s[i+1:]
+	}
+	a.pending += s
+	if len(a.pending) > maxPromptLen {
+		a.pending = a.pending[len(a.pending)-maxPromptLen:]
+	}
+}
+
+// prompt returns and clears the pending prompt.
+func (a *answerer) prompt(echo string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := a.pending
+	a.pending = ""
+	a.echo = echo
+	return p
+}
+
+// call answers `read` builtins that read the script's stdin.
+func (a *answerer) call(ctx context.Context, args []string) ([]string, error) {
+	if args[0] != "read" {
+		return args, nil
+	}
+	hc := interp.HandlerCtx(ctx)
+	if hc.Stdin != io.Reader(a.pr) {
+		return args, nil // redirected
+	}
+
+	flags, _, _ := shortFlags(args[1:], "ers", "adnNptu")
+	if _, ok := flags['u']; ok {
+		return args, nil // reads another file descriptor
+	}
+	text, ok := flags['p']
+	if p := a.prompt(text); !ok {
+		text = p
+	}
+	text = strings.TrimSpace(text)
+
+	line := hc.Pos.Line()
+	response, ok := a.answers.match(text, line)
+	switch {
+	case ok:
+		a.logger.Debugf("Answering prompt %q at %s:%d", text, a.script.Name, line)
+	case a.answers.FailOnUnexpected:
+		return nil, fmt.Errorf("unexpected prompt at %s:%d: %q", a.script.Name, line, text)
+	default:
+		a.logger.Debugf("No answer for prompt %q, reading stdin", text)
+		if a.stdin == nil {
+			return []string{"false"}, nil
+		}
+		s, err := a.readLine()
+		if s == "" && err != nil {
+			return []string{"false"}, nil // end of input, as read would report
+		}
+		response = strings.TrimSuffix(s, "\n")
+	}
+	if _, err := io.WriteString(a.pw, response+"\n"); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+func (a *answerer) close() {
+	_ = a.pw.Close()
+	_ = a.pr.Close()
+}
+
+type promptWriter struct {
+	a *answerer
+	w io.Writer
+}
+
+func (pw *promptWriter) Write(p []byte) (int, error) {
+	pw.a.wrote(p)
+	return pw.w.Write(p)
+}
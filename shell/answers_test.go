@@ -0,0 +1,181 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_Answers(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		stdin      string
+		failOn     bool
+		wantStdout string
+		wantErr    bool
+	}{
+		{
+			name:       "read -p",
+			content:    `read -p "Continue? [y/N] " ans; echo "got $ans"`,
+			wantStdout: "Continue? [y/N] got y\n",
+		},
+		{
+			name:       "prompt written before read",
+			content:    `printf "Install directory: "; read -r dir; echo "dir=$dir"`,
+			wantStdout: "Install directory: dir=/opt/tool\n",
+		},
+		{
+			name:       "several prompts",
+			content:    `read -p "Continue? " a; echo; printf "Install directory: "; read b; echo "$a $b"`,
+			wantStdout: "Continue? \nInstall directory: y /opt/tool\n",
+		},
+		{
+			name:       "redirected read is not answered",
+			content:    `read line <<EOF` + "\nfrom heredoc\nEOF\n" + `echo "$line"`,
+			wantStdout: "from heredoc\n",
+		},
+		{
+			name:       "unexpected prompt passes stdin through",
+			content:    `read -p "Name: " name; echo "hi $name"`,
+			stdin:      "bob\n",
+			wantStdout: "Name: hi bob\n",
+		},
+		{
+			name:       "unexpected prompt at end of input",
+			content:    `read -p "Name: " name || echo "no input"`,
+			wantStdout: "no input\n",
+		},
+		{
+			name:       "external command reads original stdin",
+			content:    `read -p "Continue? " ans; cat; echo "got $ans"`,
+			stdin:      "from-stdin\n",
+			wantStdout: "Continue? from-stdin\ngot y\n",
+		},
+		{
+			name:       "external command reads rest of stdin",
+			content:    `read -p "Name: " name; cat; echo "hi $name"`,
+			stdin:      "bob\nrest\n",
+			wantStdout: "Name: rest\nhi bob\n",
+		},
+		{
+			name:    "fail on unexpected prompt",
+			content: `read -p "Name: " name; echo "unreachable"`,
+			failOn:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answers := &Answers{FailOnUnexpected: tt.failOn}
+			if err := answers.Add(`Continue\?`, "y"); err != nil {
+				t.Fatalf("Add() error: %v", err)
+			}
+			if err := answers.Add(`^Install directory:`, "/opt/tool"); err != nil {
+				t.Fatalf("Add() error: %v", err)
+			}
+
+			logger := log.New("test")
+			logger.SetOutput(&bytes.Buffer{})
+			var stdout bytes.Buffer
+			script := Script{Name: "test.sh", Content: tt.content}
+			_, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(tt.stdin), &stdout, &bytes.Buffer{}, Options{Answers: answers}, logger)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), `unexpected prompt at test.sh:1: "Name:"`) {
+					t.Errorf("error = %v", err)
+				}
+				if strings.Contains(stdout.String(), "unreachable") {
+					t.Error("script continued after unexpected prompt")
+				}
+				return
+			}
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", got, tt.wantStdout)
+			}
+		})
+	}
+}
+
+func TestRunWithOptions_AnswersFileStdin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte("bob\nrest\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = stdin.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var stdout bytes.Buffer
+	script := Script{Name: "test.sh", Content: `read -p "Name: " name; head -n 1; read -p "Continue? " ans; echo "$name $ans"`}
+	answers := &Answers{}
+	if err := answers.Add(`Continue`, "y"); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+	if _, err := RunWithOptions(ctx, script, nil, stdin, &stdout, &bytes.Buffer{}, Options{Answers: answers}, log.New("test")); err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	if want := "Name: rest\nContinue? bob y\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestAnswers_Prompts(t *testing.T) {
+	answers := &Answers{}
+	if err := answers.Add(`Continue`, "y"); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+	script := Script{Name: "test.sh", Content: "read -p 'Continue? ' a\nread -p 'Other? ' b"}
+	_, _ = RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{Answers: answers}, log.New("test"))
+
+	prompts := answers.Prompts()
+	if len(prompts) != 2 {
+		t.Fatalf("Prompts() = %+v, want 2", prompts)
+	}
+	if p := prompts[0]; p.Text != "Continue?" || p.Response != "y" || !p.Answered || p.Line != 1 {
+		t.Errorf("Prompts()[0] = %+v", p)
+	}
+	if p := prompts[1]; p.Text != "Other?" || p.Answered || p.Line != 2 {
+		t.Errorf("Prompts()[1] = %+v", p)
+	}
+}
+
+func TestLoadAnswers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "answers.json")
+	data := `{"answers": [{"prompt": "^Continue", "response": "yes"}], "fail_on_unexpected": true}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	answers, err := LoadAnswers(path)
+	if err != nil {
+		t.Fatalf("LoadAnswers() error: %v", err)
+	}
+	if !answers.FailOnUnexpected || len(answers.Rules) != 1 {
+		t.Errorf("LoadAnswers() = %+v", answers)
+	}
+	if got, ok := answers.match("Continue? [y/N]", 1); !ok || got != "yes" {
+		t.Errorf("match() = %q, %v", got, ok)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"answers": [{"prompt": "(", "response": ""}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAnswers(bad); err == nil {
+		t.Error("LoadAnswers() with invalid pattern succeeded")
+	}
+}
@@ -0,0 +1,221 @@
+package shell
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/installable-sh/lib/log"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Checkpoints lets a script that failed midway resume where it left off.
+//
+// The script marks the start of each section with `checkpoint name`. A
+// section is completed when the next checkpoint is reached, or when the
+// script exits successfully after it. Completed sections are recorded in
+// File, keyed by the script's SHA-256 digest, so editing the script starts
+// over. When the script is run again, top-level sections already completed
+// are skipped, along with their checkpoint commands.
+//
+// Commands before the first checkpoint always run, so they are the place
+// for variables and functions that later sections need. Scripts should
+// `set -e`, or a failing command does not stop its section from completing.
+type Checkpoints struct {
+	// File is the JSON state file. It is created if it does not exist.
+	File string
+
+	// Force runs every section, forgetting any progress recorded for the
+	// script.
+	Force bool
+}
+
+// checkpointState is the content of Checkpoints.File.
+type checkpointState struct {
+	Scripts map[string]*scriptProgress `json:"scripts"`
+}
+
+// scriptProgress records the sections a script has completed.
+type scriptProgress struct {
+	Name      string    `json:"name"`
+	Completed []string  `json:"completed"`
+	Updated   time.Time `json:"updated"`
+}
+
+// checkpointer serves the checkpoint command and records progress for
+// the script currently being run.
+type checkpointer struct {
+	opts   Checkpoints
+	script *Script
+	logger log.DebugLogger
+
+	mu       sync.Mutex
+	digest   string
+	current  string // section being run, if any
+	progress *scriptProgress
+}
+
+func newCheckpointer(script *Script, opts Checkpoints, logger log.DebugLogger) (*checkpointer, error) {
+	if opts.File == "" {
+		return nil, errors.New("no state file")
+	}
+	return &checkpointer{opts: opts, script: script, logger: logger}, nil
+}
+
+// start loads the script's progress and removes the top-level sections
+// it has completed from prog, returning their names.
+func (c *checkpointer) start(prog *syntax.File) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(c.script.Content))
+	c.digest = hex.EncodeToString(sum[:])
+	c.current = ""
+	state, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	c.progress = state.Scripts[c.digest]
+	if c.progress != nil && c.opts.Force {
+		c.logger.Debugf("Forgetting completed checkpoints: %v", c.progress.Completed)
+		delete(state.Scripts, c.digest)
+		if err := c.save(state); err != nil {
+			return nil, err
+		}
+		c.progress = nil
+	}
+	if c.progress == nil {
+		c.progress = &scriptProgress{Name: c.script.Name}
+		return nil, nil
+	}
+
+	var skipped []string
+	skipping := false
+	stmts := prog.Stmts[:0]
+	for _, stmt := range prog.Stmts {
+		if name, ok := checkpointName(stmt); ok {
+			skipping = slices.Contains(c.progress.Completed, name)
+			if skipping {
+				skipped = append(skipped, name)
+			}
+		}
+		if !skipping {
+			stmts = append(stmts, stmt)
+		}
+	}
+	prog.Stmts = stmts
+	return skipped, nil
+}
+
+// checkpointName returns the name given to a top-level checkpoint command,
+// if stmt is one whose name is known before running it.
+func checkpointName(stmt *syntax.Stmt) (string, bool) {
+	call, ok := stmt.Cmd.(*syntax.CallExpr)
+	if !ok || stmt.Background || len(call.Assigns) > 0 || len(call.Args) != 2 || call.Args[0].Lit() != "checkpoint" {
+		return "", false
+	}
+	name := call.Args[1].Lit()
+	return name, name != ""
+}
+
+func (c *checkpointer) exec(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		if args[0] != "checkpoint" {
+			return next(ctx, args)
+		}
+		hc := interp.HandlerCtx(ctx)
+		if len(args) != 2 || args[1] == "" {
+			_, _ = fmt.Fprintln(hc.Stderr, "usage: checkpoint name")
+			return interp.ExitStatus(2)
+		}
+		if err := c.reached(args[1]); err != nil {
+			_, _ = fmt.Fprintf(hc.Stderr, "checkpoint: %v\n", err)
+			return interp.ExitStatus(1)
+		}
+		return nil
+	}
+}
+
+// reached completes the current section and starts the named one.
+func (c *checkpointer) reached(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logger.Debugf("Reached checkpoint: %s", name)
+	err := c.complete()
+	c.current = name
+	return err
+}
+
+// finish completes the last section if the script exited successfully.
+func (c *checkpointer) finish(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		return nil
+	}
+	return c.complete()
+}
+
+func (c *checkpointer) complete() error {
+	if c.current == "" || slices.Contains(c.progress.Completed, c.current) {
+		return nil
+	}
+	c.progress.Completed = append(c.progress.Completed, c.current)
+	c.progress.Updated = time.Now().UTC()
+	state, err := c.load()
+	if err != nil {
+		return err
+	}
+	state.Scripts[c.digest] = c.progress
+	return c.save(state)
+}
+
+// load reads the state file, which may not exist yet.
+func (c *checkpointer) load() (*checkpointState, error) {
+	state := &checkpointState{}
+	data, err := os.ReadFile(c.opts.File)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("invalid checkpoint file %s: %w", c.opts.File, err)
+		}
+	}
+	if state.Scripts == nil {
+		state.Scripts = map[string]*scriptProgress{}
+	}
+	return state, nil
+}
+
+// save replaces the state file, so that it is never left half-written.
+func (c *checkpointer) save(state *checkpointState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.opts.File), ".checkpoints-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.opts.File)
+}
@@ -0,0 +1,116 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_Checkpoints(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "state.json")
+	marker := filepath.Join(dir, "fail")
+	script := Script{Name: "install.sh", Content: `set -e
+echo setup
+checkpoint download
+echo download
+checkpoint build
+echo build
+if [ -f "` + marker + `" ]; then exit 3; fi
+checkpoint install
+echo install
+`}
+
+	run := func(opts Options) (*Result, string) {
+		t.Helper()
+		logger := log.New("test")
+		logger.SetOutput(&bytes.Buffer{})
+		var stdout bytes.Buffer
+		result, _ := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, opts, logger)
+		return result, stdout.String()
+	}
+	opts := Options{Checkpoints: &Checkpoints{File: state}}
+
+	if err := os.WriteFile(marker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		opts         Options
+		fix          bool
+		wantStdout   string
+		wantSkipped  []string
+		wantExitCode int
+	}{
+		{name: "fails midway", opts: opts, wantStdout: "setup\ndownload\nbuild\n", wantExitCode: 3},
+		{name: "resumes", opts: opts, fix: true, wantStdout: "setup\nbuild\ninstall\n", wantSkipped: []string{"download"}},
+		{name: "skips everything", opts: opts, wantStdout: "setup\n", wantSkipped: []string{"download", "build", "install"}},
+		{name: "forced", opts: Options{Checkpoints: &Checkpoints{File: state, Force: true}}, wantStdout: "setup\ndownload\nbuild\ninstall\n"},
+		{name: "after force", opts: opts, wantStdout: "setup\n", wantSkipped: []string{"download", "build", "install"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fix {
+				_ = os.Remove(marker)
+			}
+			result, stdout := run(tt.opts)
+			if result.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantExitCode)
+			}
+			if stdout != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if !slices.Equal(result.Skipped, tt.wantSkipped) {
+				t.Errorf("Skipped = %q, want %q", result.Skipped, tt.wantSkipped)
+			}
+		})
+	}
+
+	// Editing the script starts over.
+	script.Content += "echo done\n"
+	if _, stdout := run(opts); stdout != "setup\ndownload\nbuild\ninstall\ndone\n" {
+		t.Errorf("edited script stdout = %q", stdout)
+	}
+}
+
+func TestCheckpointCommand(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state.json")
+	tests := []struct {
+		name         string
+		script       string
+		wantStderr   string
+		wantExitCode int
+	}{
+		{name: "dynamic name", script: "name=a; checkpoint \"$name\"; f() { checkpoint b; }; f"},
+		{name: "no name", script: "checkpoint", wantStderr: "usage: checkpoint name\n", wantExitCode: 2},
+		{name: "two names", script: "checkpoint a b", wantStderr: "usage: checkpoint name\n", wantExitCode: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := log.New("test")
+			logger.SetOutput(&bytes.Buffer{})
+			var stderr bytes.Buffer
+			opts := Options{Checkpoints: &Checkpoints{File: state}}
+			result, _ := RunWithOptions(context.Background(), Script{Name: "t.sh", Content: tt.script}, nil, nil, &bytes.Buffer{}, &stderr, opts, logger)
+			if result.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantExitCode)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+	data, err := os.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"completed": [`) || !strings.Contains(string(data), `"b"`) {
+		t.Errorf("state file = %s", data)
+	}
+}
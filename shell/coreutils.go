@@ -0,0 +1,746 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// utility is an in-process implementation of an external command.
+// It returns nil on success or an interp.ExitStatus on failure.
+type utility func(ctx context.Context, hc interp.HandlerContext, args []string) error
+
+// coreutils lists the utilities provided when Options.Coreutils is set.
+// Commands the interpreter implements itself, such as echo, printf, test
+// and [, never reach the exec handler and are not listed here.
+var coreutils map[string]utility
+
+func init() {
+	coreutils = map[string]utility{
+		"basename": utilBasename,
+		"cat":      utilCat,
+		"chmod":    utilChmod,
+		"cp":       utilCp,
+		"dirname":  utilDirname,
+		"env":      utilEnv,
+		"grep":     utilGrep,
+		"head":     utilHead,
+		"ln":       utilLn,
+		"mkdir":    utilMkdir,
+		"mktemp":   utilMktemp,
+		"mv":       utilMv,
+		"rm":       utilRm,
+		"rmdir":    utilRmdir,
+		"sed":      utilSed,
+		"sleep":    utilSleep,
+		"tail":     utilTail,
+		"touch":    utilTouch,
+		"uname":    utilUname,
+		"wc":       utilWc,
+		"which":    utilWhich,
+	}
+}
+
+// coreutilsMiddleware serves commands from coreutils when their binary
+// cannot be found on PATH.
+func coreutilsMiddleware(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		util, ok := coreutils[args[0]]
+		if !ok {
+			return next(ctx, args)
+		}
+		hc := execCtx(ctx)
+		if _, err := interp.LookPathDir(hc.Dir, hc.Env, args[0]); err == nil {
+			return next(ctx, args)
+		}
+		return util(ctx, hc, args)
+	}
+}
+
+// utilError reports a failure the way coreutils do and returns status 1.
+func utilError(hc interp.HandlerContext, name string, format string, args ...any) error {
+	_, _ = fmt.Fprintf(hc.Stderr, "%s: %s\n", name, fmt.Sprintf(format, args...))
+	return interp.ExitStatus(1)
+}
+
+// shortFlags splits leading short options from operands. Options listed
+// in boolean are switches, stored with a value of "". Options listed in
+// valued take a value from the rest of the cluster or the next argument.
+// Long options and options in neither list are errors, since guessing at
+// their meaning could do something the script did not ask for.
+func shortFlags(args []string, boolean, valued string) (map[byte]string, []string, error) {
+	flags := map[byte]string{}
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			return flags, args[1:], nil
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		if arg[1] == '-' {
+			return nil, nil, fmt.Errorf("unrecognized option '%s'", arg)
+		}
+		args = args[1:]
+		for i := 1; i < len(arg); i++ {
+			c := arg[i]
+			if strings.IndexByte(boolean, c) >= 0 {
+				flags[c] = ""
+				continue
+			}
+			if strings.IndexByte(valued, c) < 0 {
+				return nil, nil, fmt.Errorf("invalid option -- '%c'", c)
+			}
+			switch {
+			case i+1 < len(arg):
+				flags[c] = arg[i+1:]
+			case len(args) > 0:
+				flags[c] = args[0]
+				args = args[1:]
+			default:
+				return nil, nil, fmt.Errorf("option requires an argument -- '%c'", c)
+			}
+			break
+		}
+	}
+	return flags, args, nil
+}
+
+// usageError reports invalid options the way coreutils do and returns
+// status 2.
+func usageError(hc interp.HandlerContext, name string, err error) error {
+	_, _ = fmt.Fprintf(hc.Stderr, "%s: %v\n", name, err)
+	return interp.ExitStatus(2)
+}
+
+func hasFlag(flags map[byte]string, names string) bool {
+	for i := 0; i < len(names); i++ {
+		if _, ok := flags[names[i]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// absPath resolves p against the interpreter's working directory.
+func absPath(hc interp.HandlerContext, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(hc.Dir, p)
+}
+
+// openInputs returns a reader over the named files, or stdin if there are
+// none. The file name "-" also means stdin.
+func openInputs(hc interp.HandlerContext, names []string) (io.Reader, func(), error) {
+	stdin := hc.Stdin
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	if len(names) == 0 {
+		return stdin, func() {}, nil
+	}
+	var readers []io.Reader
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+	for _, name := range names {
+		if name == "-" {
+			readers = append(readers, stdin)
+			continue
+		}
+		f, err := os.Open(absPath(hc, name))
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		readers = append(readers, f)
+	}
+	return io.MultiReader(readers...), closeAll, nil
+}
+
+func utilBasename(_ context.Context, hc interp.HandlerContext, args []string) error {
+	if len(args) < 2 {
+		return utilError(hc, "basename", "missing operand")
+	}
+	base := filepath.Base(args[1])
+	if len(args) > 2 && base != args[2] {
+		base = strings.TrimSuffix(base, args[2])
+	}
+	_, _ = fmt.Fprintln(hc.Stdout, base)
+	return nil
+}
+
+func utilDirname(_ context.Context, hc interp.HandlerContext, args []string) error {
+	if len(args) < 2 {
+		return utilError(hc, "dirname", "missing operand")
+	}
+	for _, arg := range args[1:] {
+		_, _ = fmt.Fprintln(hc.Stdout, filepath.Dir(arg))
+	}
+	return nil
+}
+
+func utilCat(_ context.Context, hc interp.HandlerContext, args []string) error {
+	_, operands, err := shortFlags(args[1:], "", "")
+	if err != nil {
+		return usageError(hc, "cat", err)
+	}
+	r, closeAll, err := openInputs(hc, operands)
+	if err != nil {
+		return utilError(hc, "cat", "%v", err)
+	}
+	defer closeAll()
+	if _, err := io.Copy(hc.Stdout, r); err != nil {
+		return utilError(hc, "cat", "%v", err)
+	}
+	return nil
+}
+
+func utilMkdir(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := shortFlags(args[1:], "p", "m")
+	if err != nil {
+		return usageError(hc, "mkdir", err)
+	}
+	mode := os.FileMode(0o755)
+	if m, ok := flags['m']; ok {
+		parsed, err := strconv.ParseUint(m, 8, 32)
+		if err != nil {
+			return utilError(hc, "mkdir", "invalid mode %q", m)
+		}
+		mode = os.FileMode(parsed)
+	}
+	if len(operands) == 0 {
+		return utilError(hc, "mkdir", "missing operand")
+	}
+	for _, dir := range operands {
+		trackWrite(ctx, absPath(hc, dir))
+		if hasFlag(flags, "p") {
+			err = os.MkdirAll(absPath(hc, dir), mode)
+		} else {
+			err = os.Mkdir(absPath(hc, dir), mode)
+		}
+		if err != nil {
+			return utilError(hc, "mkdir", "%v", err)
+		}
+	}
+	return nil
+}
+
+func utilRm(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := shortFlags(args[1:], "frR", "")
+	if err != nil {
+		return usageError(hc, "rm", err)
+	}
+	force := hasFlag(flags, "f")
+	recursive := hasFlag(flags, "rR")
+	if len(operands) == 0 && !force {
+		return utilError(hc, "rm", "missing operand")
+	}
+	for _, name := range operands {
+		p := absPath(hc, name)
+		if recursive && filepath.Clean(p) == "/" {
+			_, _ = fmt.Fprintln(hc.Stderr, "rm: it is dangerous to operate recursively on '/'")
+			return utilError(hc, "rm", "use --no-preserve-root to override this failsafe")
+		}
+		info, err := os.Lstat(p)
+		if err != nil {
+			if force && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return utilError(hc, "rm", "%v", err)
+		}
+		trackWrite(ctx, p)
+		if info.IsDir() {
+			if !recursive {
+				return utilError(hc, "rm", "cannot remove '%s': Is a directory", name)
+			}
+			err = os.RemoveAll(p)
+		} else {
+			err = os.Remove(p)
+		}
+		if err != nil {
+			return utilError(hc, "rm", "%v", err)
+		}
+	}
+	return nil
+}
+
+func utilRmdir(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	_, operands, err := shortFlags(args[1:], "", "")
+	if err != nil {
+		return usageError(hc, "rmdir", err)
+	}
+	for _, dir := range operands {
+		trackWrite(ctx, absPath(hc, dir))
+		if err := os.Remove(absPath(hc, dir)); err != nil {
+			return utilError(hc, "rmdir", "%v", err)
+		}
+	}
+	return nil
+}
+
+func utilTouch(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	_, operands, err := shortFlags(args[1:], "", "")
+	if err != nil {
+		return usageError(hc, "touch", err)
+	}
+	now := time.Now()
+	for _, name := range operands {
+		p := absPath(hc, name)
+		trackWrite(ctx, p)
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return utilError(hc, "touch", "%v", err)
+		}
+		_ = f.Close()
+		if err := os.Chtimes(p, now, now); err != nil {
+			return utilError(hc, "touch", "%v", err)
+		}
+	}
+	return nil
+}
+
+// targets resolves the destination of cp, mv and ln: with several sources
+// or an existing directory as the last operand, each source goes inside it.
+// With noDereference, a symlink to a directory is not treated as one.
+func targets(hc interp.HandlerContext, name string, operands []string, noDereference bool) ([][2]string, error) {
+	if len(operands) < 2 {
+		return nil, utilError(hc, name, "missing file operand")
+	}
+	sources, dest := operands[:len(operands)-1], absPath(hc, operands[len(operands)-1])
+	stat := os.Stat
+	if noDereference {
+		stat = os.Lstat
+	}
+	info, err := stat(dest)
+	intoDir := err == nil && info.IsDir()
+	if len(sources) > 1 && !intoDir {
+		return nil, utilError(hc, name, "target '%s' is not a directory", operands[len(operands)-1])
+	}
+	pairs := make([][2]string, len(sources))
+	for i, src := range sources {
+		to := dest
+		if intoDir {
+			to = filepath.Join(dest, filepath.Base(src))
+		}
+		pairs[i] = [2]string{src, to}
+	}
+	return pairs, nil
+}
+
+func utilCp(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := shortFlags(args[1:], "rRaf", "")
+	if err != nil {
+		return usageError(hc, "cp", err)
+	}
+	pairs, err := targets(hc, "cp", operands, false)
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		src := absPath(hc, pair[0])
+		info, err := os.Stat(src)
+		if err != nil {
+			return utilError(hc, "cp", "%v", err)
+		}
+		trackWrite(ctx, pair[1])
+		if info.IsDir() {
+			if !hasFlag(flags, "rRa") {
+				return utilError(hc, "cp", "-r not specified; omitting directory '%s'", pair[0])
+			}
+			err = copyTree(src, pair[1])
+		} else {
+			err = copyFile(src, pair[1], info.Mode())
+		}
+		if err != nil {
+			return utilError(hc, "cp", "%v", err)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		to := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(to, info.Mode().Perm())
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, to)
+		default:
+			return copyFile(p, to, info.Mode())
+		}
+	})
+}
+
+func utilMv(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	_, operands, err := shortFlags(args[1:], "f", "")
+	if err != nil {
+		return usageError(hc, "mv", err)
+	}
+	pairs, err := targets(hc, "mv", operands, false)
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		trackWrite(ctx, absPath(hc, pair[0]))
+		trackWrite(ctx, pair[1])
+		if err := move(absPath(hc, pair[0]), pair[1]); err != nil {
+			return utilError(hc, "mv", "%v", err)
+		}
+	}
+	return nil
+}
+
+// move renames src to dst, copying and then removing src when they are
+// on different file systems.
+func move(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		err = copyTree(src, dst)
+	case info.Mode()&fs.ModeSymlink != 0:
+		var link string
+		if link, err = os.Readlink(src); err == nil {
+			err = os.Symlink(link, dst)
+		}
+	default:
+		err = copyFile(src, dst, info.Mode())
+	}
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+func utilLn(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := shortFlags(args[1:], "sfn", "")
+	if err != nil {
+		return usageError(hc, "ln", err)
+	}
+	pairs, err := targets(hc, "ln", operands, hasFlag(flags, "n"))
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		trackWrite(ctx, pair[1])
+		if hasFlag(flags, "f") {
+			_ = os.Remove(pair[1])
+		}
+		if hasFlag(flags, "s") {
+			err = os.Symlink(pair[0], pair[1])
+		} else {
+			err = os.Link(absPath(hc, pair[0]), pair[1])
+		}
+		if err != nil {
+			return utilError(hc, "ln", "%v", err)
+		}
+	}
+	return nil
+}
+
+func utilChmod(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := shortFlags(args[1:], "R", "")
+	if err != nil {
+		return usageError(hc, "chmod", err)
+	}
+	if len(operands) < 2 {
+		return utilError(hc, "chmod", "missing operand")
+	}
+	mode := operands[0]
+	for _, name := range operands[1:] {
+		root := absPath(hc, name)
+		trackWrite(ctx, root)
+		apply := func(p string) error {
+			info, err := os.Stat(p)
+			if err != nil {
+				return err
+			}
+			perm, err := parseMode(mode, info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			return os.Chmod(p, perm)
+		}
+		if hasFlag(flags, "R") {
+			err = filepath.WalkDir(root, func(p string, _ fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				return apply(p)
+			})
+		} else {
+			err = apply(root)
+		}
+		if err != nil {
+			return utilError(hc, "chmod", "%v", err)
+		}
+	}
+	return nil
+}
+
+// parseMode applies an octal or symbolic mode such as "755", "+x" or
+// "u+rw,go-w" to the current permissions.
+func parseMode(mode string, current fs.FileMode) (fs.FileMode, error) {
+	if n, err := strconv.ParseUint(mode, 8, 32); err == nil {
+		return fs.FileMode(n), nil
+	}
+	perm := current
+	for _, clause := range strings.Split(mode, ",") {
+		i := strings.IndexAny(clause, "+-=")
+		if i < 0 {
+			return 0, fmt.Errorf("invalid mode: %q", mode)
+		}
+		who, op, what := clause[:i], clause[i], clause[i+1:]
+		var mask fs.FileMode
+		if who == "" || strings.Contains(who, "a") {
+			who = "ugo"
+		}
+		for _, w := range who {
+			switch w {
+			case 'u':
+				mask |= 0o700
+			case 'g':
+				mask |= 0o070
+			case 'o':
+				mask |= 0o007
+			default:
+				return 0, fmt.Errorf("invalid mode: %q", mode)
+			}
+		}
+		var bits fs.FileMode
+		for _, p := range what {
+			switch p {
+			case 'r':
+				bits |= 0o444
+			case 'w':
+				bits |= 0o222
+			case 'x':
+				bits |= 0o111
+			default:
+				return 0, fmt.Errorf("invalid mode: %q", mode)
+			}
+		}
+		switch op {
+		case '+':
+			perm |= bits & mask
+		case '-':
+			perm &^= bits & mask
+		case '=':
+			perm = perm&^mask | bits&mask
+		}
+	}
+	return perm, nil
+}
+
+func utilUname(_ context.Context, hc interp.HandlerContext, args []string) error {
+	flags, _, err := shortFlags(args[1:], "asnrm", "")
+	if err != nil {
+		return usageError(hc, "uname", err)
+	}
+	all := hasFlag(flags, "a")
+	var fields []string
+	if all || hasFlag(flags, "s") || len(flags) == 0 {
+		fields = append(fields, kernelName())
+	}
+	if all || hasFlag(flags, "n") {
+		host, _ := os.Hostname()
+		fields = append(fields, host)
+	}
+	if all || hasFlag(flags, "r") {
+		fields = append(fields, kernelRelease())
+	}
+	if all || hasFlag(flags, "m") {
+		fields = append(fields, machine())
+	}
+	_, _ = fmt.Fprintln(hc.Stdout, strings.Join(fields, " "))
+	return nil
+}
+
+func kernelName() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "Darwin"
+	case "freebsd":
+		return "FreeBSD"
+	case "netbsd":
+		return "NetBSD"
+	case "openbsd":
+		return "OpenBSD"
+	case "windows":
+		return "Windows_NT"
+	default:
+		return strings.ToUpper(runtime.GOOS[:1]) + runtime.GOOS[1:]
+	}
+}
+
+func kernelRelease() string {
+	if b, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		return strings.TrimSpace(string(b))
+	}
+	return "unknown"
+}
+
+// machine returns the hardware name as uname -m reports it.
+func machine() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i686"
+	case "arm64":
+		if runtime.GOOS == "linux" {
+			return "aarch64"
+		}
+		return "arm64"
+	case "arm":
+		return "armv7l"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+func utilSleep(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	var total time.Duration
+	for _, arg := range args[1:] {
+		unit := time.Second
+		switch {
+		case strings.HasSuffix(arg, "s"):
+			arg = strings.TrimSuffix(arg, "s")
+		case strings.HasSuffix(arg, "m"):
+			arg, unit = strings.TrimSuffix(arg, "m"), time.Minute
+		case strings.HasSuffix(arg, "h"):
+			arg, unit = strings.TrimSuffix(arg, "h"), time.Hour
+		}
+		n, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return utilError(hc, "sleep", "invalid time interval '%s'", arg)
+		}
+		total += time.Duration(n * float64(unit))
+	}
+	select {
+	case <-time.After(total):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func utilMktemp(_ context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := shortFlags(args[1:], "d", "p")
+	if err != nil {
+		return usageError(hc, "mktemp", err)
+	}
+	dir := hc.Env.Get("TMPDIR").String()
+	if d, ok := flags['p']; ok {
+		dir = d
+	}
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	pattern := "tmp.XXXXXXXXXX"
+	if len(operands) > 0 {
+		pattern = operands[0]
+		if strings.Contains(pattern, "/") {
+			dir, pattern = filepath.Dir(absPath(hc, pattern)), filepath.Base(pattern)
+		}
+	}
+	// os.MkdirTemp and os.CreateTemp replace the last "*" with random text.
+	pattern = strings.TrimRight(pattern, "X") + "*"
+	var name string
+	if hasFlag(flags, "d") {
+		name, err = os.MkdirTemp(dir, pattern)
+	} else {
+		var f *os.File
+		if f, err = os.CreateTemp(dir, pattern); err == nil {
+			name = f.Name()
+			_ = f.Close()
+		}
+	}
+	if err != nil {
+		return utilError(hc, "mktemp", "%v", err)
+	}
+	_, _ = fmt.Fprintln(hc.Stdout, name)
+	return nil
+}
+
+func utilEnv(_ context.Context, hc interp.HandlerContext, args []string) error {
+	if len(args) > 1 {
+		// Running a command through env needs the full exec machinery.
+		return utilError(hc, "env", "running commands is not supported")
+	}
+	for name, vr := range hc.Env.Each {
+		if vr.Exported {
+			_, _ = fmt.Fprintf(hc.Stdout, "%s=%s\n", name, vr.String())
+		}
+	}
+	return nil
+}
+
+func utilWhich(_ context.Context, hc interp.HandlerContext, args []string) error {
+	status := error(nil)
+	for _, name := range args[1:] {
+		p, err := interp.LookPathDir(hc.Dir, hc.Env, name)
+		if err != nil {
+			status = interp.ExitStatus(1)
+			continue
+		}
+		_, _ = fmt.Fprintln(hc.Stdout, p)
+	}
+	return status
+}
@@ -0,0 +1,223 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_Coreutils(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		stdin      string
+		wantStdout string
+		wantErr    bool
+	}{
+		{
+			name:       "mkdir cp cat",
+			content:    `mkdir -p "$1/a/b" && printf 'x\n' > "$1/f" && cp "$1/f" "$1/a/b/" && cat "$1/a/b/f"`,
+			wantStdout: "x\n",
+		},
+		{
+			name:       "mv and rm",
+			content:    `touch "$1/f" && mv "$1/f" "$1/g" && rm "$1/g" && [ ! -e "$1/g" ] && echo gone`,
+			wantStdout: "gone\n",
+		},
+		{
+			name:       "ln and chmod",
+			content:    `touch "$1/f" && chmod +x "$1/f" && ln -s "$1/f" "$1/l" && [ -x "$1/l" ] && echo ok`,
+			wantStdout: "ok\n",
+		},
+		{
+			name:       "basename dirname",
+			content:    `basename /usr/local/bin/tool.sh .sh; dirname /usr/local/bin/tool`,
+			wantStdout: "tool\n/usr/local/bin\n",
+		},
+		{
+			name:       "grep",
+			content:    `grep -n 'b\(a\)r' -`,
+			stdin:      "foo\nbar\nbaz\n",
+			wantStdout: "2:bar\n",
+		},
+		{
+			name:    "grep no match",
+			content: `grep -q nope`,
+			stdin:   "foo\n",
+			wantErr: true,
+		},
+		{
+			name:       "sed substitute and delete",
+			content:    `sed -e 's/o/0/g;/^#/d'`,
+			stdin:      "# comment\nfoo\n",
+			wantStdout: "f00\n",
+		},
+		{
+			name:       "sed backreference",
+			content:    `sed -E 's|(a+)(b)|\2\1&|'`,
+			stdin:      "aab\n",
+			wantStdout: "baaaab\n",
+		},
+		{
+			name:       "head tail wc",
+			content:    `printf '1\n2\n3\n4\n' | head -n 3 | tail -2; printf 'a b\nc\n' | wc -l`,
+			wantStdout: "2\n3\n2\n",
+		},
+		{
+			name:       "tail from line",
+			content:    `printf '1\n2\n3\n' | tail -n +2`,
+			wantStdout: "2\n3\n",
+		},
+		{
+			name:       "long option rejected",
+			content:    `mkdir "$1/d"; rm --force "$1/d"; echo $?; [ -d "$1/d" ] && echo kept`,
+			wantStdout: "2\nkept\n",
+		},
+		{
+			name:       "unknown option rejected",
+			content:    `head -c 3; echo $?; grep -r x "$1"; echo $?`,
+			wantStdout: "2\n2\n",
+		},
+		{
+			name:       "ln replaces symlink to directory",
+			content:    `mkdir "$1/a" "$1/b" && ln -s "$1/a" "$1/cur" && ln -sfn "$1/b" "$1/cur" && [ ! -e "$1/a/b" ] && cd "$1/cur" && basename "$(pwd -P)"`,
+			wantStdout: "b\n",
+		},
+		{
+			// The path does not exist, so nothing is removed even if the
+			// refusal is missing.
+			name:       "rm preserves root",
+			content:    `rm -r /no-such-dir/.. 2>&1 | grep -c dangerous`,
+			wantStdout: "1\n",
+		},
+		{
+			name:       "uname",
+			content:    `[ -n "$(uname -s)" ] && [ -n "$(uname -m)" ] && echo ok`,
+			wantStdout: "ok\n",
+		},
+		{
+			name:    "unknown command",
+			content: `no-such-command`,
+			wantErr: true,
+		},
+	}
+
+	// An empty PATH forces the in-process implementations to be used.
+	t.Setenv("PATH", t.TempDir())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var stdout bytes.Buffer
+			script := Script{Content: tt.content, Name: "test.sh"}
+
+			var stderr bytes.Buffer
+			_, err := RunWithOptions(context.Background(), script, []string{dir}, strings.NewReader(tt.stdin), &stdout, &stderr, Options{Coreutils: true}, log.New("test"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunWithOptions() error = %v, wantErr %v, stderr: %s", err, tt.wantErr, stderr.String())
+			}
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", got, tt.wantStdout)
+			}
+		})
+	}
+}
+
+func TestRunWithOptions_CoreutilsSedInPlace(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	file := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(file, []byte("version=1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	script := Script{Content: `sed -i 's/=1/=2/' "$1"`, Name: "test.sh"}
+	_, err := RunWithOptions(context.Background(), script, []string{file}, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{Coreutils: true}, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "version=2\n" {
+		t.Errorf("content = %q, want %q", content, "version=2\n")
+	}
+}
+
+func TestRunWithOptions_CoreutilsMvAcrossDevices(t *testing.T) {
+	// /dev/shm is usually a tmpfs, separate from the temporary directory.
+	src, err := os.MkdirTemp("/dev/shm", "mv")
+	if err != nil {
+		t.Skip("/dev/shm is not available")
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(src) })
+	dst := t.TempDir()
+	t.Setenv("PATH", t.TempDir())
+
+	script := Script{Content: `
+mkdir "$1/dir" && echo x >"$1/dir/f" && echo y >"$1/file"
+mv "$1/file" "$2/file"
+mv "$1/dir" "$2/dir"`, Name: "test.sh"}
+	var stderr bytes.Buffer
+	_, err = RunWithOptions(context.Background(), script, []string{src, dst}, strings.NewReader(""), &bytes.Buffer{}, &stderr, Options{Coreutils: true}, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v, stderr: %s", err, stderr.String())
+	}
+
+	for name, want := range map[string]string{"file": "y\n", "dir/f": "x\n"} {
+		if content, err := os.ReadFile(filepath.Join(dst, name)); err != nil || string(content) != want {
+			t.Errorf("%s = %q, %v, want %q", name, content, err, want)
+		}
+		if _, err := os.Lstat(filepath.Join(src, name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s still in source: %v", name, err)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		current fs.FileMode
+		want    fs.FileMode
+	}{
+		{mode: "755", current: 0o600, want: 0o755},
+		{mode: "+x", current: 0o644, want: 0o755},
+		{mode: "u+x", current: 0o644, want: 0o744},
+		{mode: "go-w", current: 0o666, want: 0o644},
+		{mode: "a=r", current: 0o777, want: 0o444},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMode(tt.mode, tt.current)
+		if err != nil {
+			t.Errorf("parseMode(%q) error: %v", tt.mode, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseMode(%q, %o) = %o, want %o", tt.mode, tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestBasicToExtended(t *testing.T) {
+	tests := map[string]string{
+		`a\(b\)c`: `a(b)c`,
+		`a(b)c`:   `a\(b\)c`,
+		`x\{2\}`:  `x{2}`,
+		`a+b?`:    `a\+b\?`,
+		`\.`:      `\.`,
+	}
+	for in, want := range tests {
+		if got := basicToExtended(in); got != want {
+			t.Errorf("basicToExtended(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
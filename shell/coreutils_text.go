@@ -0,0 +1,497 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// compilePattern compiles a grep or sed pattern. Basic regular expressions
+// are translated to the RE2 syntax used by regexp.
+func compilePattern(pattern string, extended, fixed, ignoreCase bool) (*regexp.Regexp, error) {
+	switch {
+	case fixed:
+		pattern = regexp.QuoteMeta(pattern)
+	case !extended:
+		pattern = basicToExtended(pattern)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// basicToExtended converts a POSIX basic regular expression, where
+// \( \) \{ \} \+ \? \| are operators and their bare forms are literals,
+// to extended syntax.
+func basicToExtended(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			switch n := pattern[i]; n {
+			case '(', ')', '{', '}', '+', '?', '|':
+				sb.WriteByte(n)
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(n)
+			}
+		case strings.IndexByte("(){}+?|", c) >= 0:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func utilGrep(_ context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := shortFlags(args[1:], "EFiqsvcnloh", "e")
+	if err != nil {
+		return usageError(hc, "grep", err)
+	}
+	pattern, ok := flags['e']
+	if !ok {
+		if len(operands) == 0 {
+			_, _ = fmt.Fprintln(hc.Stderr, "grep: missing pattern")
+			return interp.ExitStatus(2)
+		}
+		pattern, operands = operands[0], operands[1:]
+	}
+	re, err := compilePattern(pattern, hasFlag(flags, "E"), hasFlag(flags, "F"), hasFlag(flags, "i"))
+	if err != nil {
+		_, _ = fmt.Fprintf(hc.Stderr, "grep: %v\n", err)
+		return interp.ExitStatus(2)
+	}
+
+	names := operands
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	quiet, invert, count := hasFlag(flags, "qs"), hasFlag(flags, "v"), hasFlag(flags, "c")
+	lineNumbers, onlyNames, onlyMatching := hasFlag(flags, "n"), hasFlag(flags, "l"), hasFlag(flags, "o")
+	withName := len(names) > 1 && !hasFlag(flags, "h")
+
+	matched := false
+	for _, name := range names {
+		r, closeAll, err := openInputs(hc, []string{name})
+		if err != nil {
+			_, _ = fmt.Fprintf(hc.Stderr, "grep: %v\n", err)
+			return interp.ExitStatus(2)
+		}
+		prefix := ""
+		if withName {
+			prefix = name + ":"
+		}
+		n, lineNo := 0, 0
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			lineNo++
+			line := scanner.Text()
+			if re.MatchString(line) == invert {
+				continue
+			}
+			n++
+			matched = true
+			if quiet {
+				closeAll()
+				return nil
+			}
+			if count || onlyNames {
+				continue
+			}
+			linePrefix := prefix
+			if lineNumbers {
+				linePrefix += strconv.Itoa(lineNo) + ":"
+			}
+			if onlyMatching && !invert {
+				for _, m := range re.FindAllString(line, -1) {
+					_, _ = fmt.Fprintf(hc.Stdout, "%s%s\n", linePrefix, m)
+				}
+				continue
+			}
+			_, _ = fmt.Fprintf(hc.Stdout, "%s%s\n", linePrefix, line)
+		}
+		closeAll()
+		switch {
+		case count:
+			_, _ = fmt.Fprintf(hc.Stdout, "%s%d\n", prefix, n)
+		case onlyNames && n > 0:
+			_, _ = fmt.Fprintln(hc.Stdout, name)
+		}
+	}
+	if !matched {
+		return interp.ExitStatus(1)
+	}
+	return nil
+}
+
+// sedCommand is a single command of the supported sed subset:
+// an optional line number or /regex/ address followed by s, d or p.
+type sedCommand struct {
+	line    int
+	addr    *regexp.Regexp
+	cmd     byte
+	re      *regexp.Regexp
+	repl    string
+	global  bool
+	printed bool
+}
+
+func (c *sedCommand) matches(lineNo int, line string) bool {
+	switch {
+	case c.line > 0:
+		return lineNo == c.line
+	case c.addr != nil:
+		return c.addr.MatchString(line)
+	}
+	return true
+}
+
+// parseSed parses a sed script made of commands separated by ";" or
+// newlines, such as "s/foo/bar/g;/^#/d".
+func parseSed(script string, extended bool) ([]*sedCommand, error) {
+	var cmds []*sedCommand
+	s := script
+	for {
+		s = strings.TrimLeft(s, " \t\n;")
+		if s == "" {
+			return cmds, nil
+		}
+		c := &sedCommand{}
+		switch {
+		case s[0] >= '0' && s[0] <= '9':
+			i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+			if i < 0 {
+				i = len(s)
+			}
+			c.line, _ = strconv.Atoi(s[:i])
+			s = s[i:]
+		case s[0] == '/':
+			pattern, rest, err := sedField(s[1:], '/')
+			if err != nil {
+				return nil, err
+			}
+			if c.addr, err = compilePattern(pattern, extended, false, false); err != nil {
+				return nil, err
+			}
+			s = rest
+		}
+		if s == "" {
+			return nil, fmt.Errorf("missing command in %q", script)
+		}
+		c.cmd, s = s[0], s[1:]
+		switch c.cmd {
+		case 'd', 'p':
+		case 's':
+			if s == "" {
+				return nil, fmt.Errorf("unterminated `s' command")
+			}
+			delim, size := utf8.DecodeRuneInString(s)
+			pattern, rest, err := sedField(s[size:], delim)
+			if err != nil {
+				return nil, err
+			}
+			repl, rest, err := sedField(rest, delim)
+			if err != nil {
+				return nil, err
+			}
+			if c.re, err = compilePattern(pattern, extended, false, false); err != nil {
+				return nil, err
+			}
+			c.repl = sedReplacement(repl)
+			for rest != "" && rest[0] != ';' && rest[0] != '\n' {
+				switch rest[0] {
+				case 'g':
+					c.global = true
+				case 'p':
+					c.printed = true
+				case 'I':
+					c.re = regexp.MustCompile("(?i)" + c.re.String())
+				case ' ', '\t':
+				default:
+					return nil, fmt.Errorf("unknown option to `s': %q", rest[0])
+				}
+				rest = rest[1:]
+			}
+			s = rest
+		default:
+			return nil, fmt.Errorf("unsupported command: %q", c.cmd)
+		}
+		cmds = append(cmds, c)
+	}
+}
+
+// sedField reads up to an unescaped delim, unescaping the delimiter.
+func sedField(s string, delim rune) (string, string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\\' && i+size < len(s):
+			next, nsize := utf8.DecodeRuneInString(s[i+size:])
+			if next != delim {
+				sb.WriteRune('\\')
+			}
+			sb.WriteRune(next)
+			i += size + nsize
+		case r == delim:
+			return sb.String(), s[i+size:], nil
+		default:
+			sb.WriteRune(r)
+			i += size
+		}
+	}
+	return "", "", fmt.Errorf("unterminated address or command")
+}
+
+// sedReplacement converts & and \1..\9 to regexp template syntax.
+func sedReplacement(repl string) string {
+	var sb strings.Builder
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		switch {
+		case c == '\\' && i+1 < len(repl):
+			i++
+			switch n := repl[i]; {
+			case n >= '0' && n <= '9':
+				sb.WriteString("${" + string(n) + "}")
+			case n == 'n':
+				sb.WriteByte('\n')
+			case n == '$':
+				sb.WriteString("$$")
+			default:
+				sb.WriteByte(n)
+			}
+		case c == '&':
+			sb.WriteString("${0}")
+		case c == '$':
+			sb.WriteString("$$")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func runSed(cmds []*sedCommand, r io.Reader, w io.Writer, quiet bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	lineNo := 0
+lines:
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		for _, c := range cmds {
+			if !c.matches(lineNo, line) {
+				continue
+			}
+			switch c.cmd {
+			case 'd':
+				continue lines
+			case 'p':
+				_, _ = fmt.Fprintln(w, line)
+			case 's':
+				replaced := line
+				if c.global {
+					replaced = c.re.ReplaceAllString(line, c.repl)
+				} else if loc := c.re.FindStringSubmatchIndex(line); loc != nil {
+					var dst []byte
+					dst = c.re.ExpandString(dst, c.repl, line, loc)
+					replaced = line[:loc[0]] + string(dst) + line[loc[1]:]
+				}
+				if c.printed && replaced != line {
+					_, _ = fmt.Fprintln(w, replaced)
+				}
+				line = replaced
+			}
+		}
+		if !quiet {
+			_, _ = fmt.Fprintln(w, line)
+		}
+	}
+	return scanner.Err()
+}
+
+func utilSed(ctx context.Context, hc interp.HandlerContext, args []string) error {
+	// -i takes an optional suffix attached to the flag, so handle it
+	// before the generic flag parser sees it.
+	inPlace := false
+	var rest []string
+	for i, arg := range args[1:] {
+		if arg == "--" {
+			rest = append(rest, args[1+i:]...)
+			break
+		}
+		if strings.HasPrefix(arg, "-i") {
+			inPlace = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	flags, operands, err := shortFlags(rest, "nEr", "e")
+	if err != nil {
+		return usageError(hc, "sed", err)
+	}
+	script, ok := flags['e']
+	if !ok {
+		if len(operands) == 0 {
+			return utilError(hc, "sed", "no script specified")
+		}
+		script, operands = operands[0], operands[1:]
+	}
+	cmds, err := parseSed(script, hasFlag(flags, "Er"))
+	if err != nil {
+		return utilError(hc, "sed", "-e expression: %v", err)
+	}
+	quiet := hasFlag(flags, "n")
+
+	if !inPlace {
+		r, closeAll, err := openInputs(hc, operands)
+		if err != nil {
+			return utilError(hc, "sed", "%v", err)
+		}
+		defer closeAll()
+		if err := runSed(cmds, r, hc.Stdout, quiet); err != nil {
+			return utilError(hc, "sed", "%v", err)
+		}
+		return nil
+	}
+
+	for _, name := range operands {
+		p := absPath(hc, name)
+		info, err := os.Stat(p)
+		if err != nil {
+			return utilError(hc, "sed", "%v", err)
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return utilError(hc, "sed", "%v", err)
+		}
+		var out bytes.Buffer
+		if err := runSed(cmds, bytes.NewReader(content), &out, quiet); err != nil {
+			return utilError(hc, "sed", "%v", err)
+		}
+		trackWrite(ctx, p)
+		if err := os.WriteFile(p, out.Bytes(), info.Mode().Perm()); err != nil {
+			return utilError(hc, "sed", "%v", err)
+		}
+	}
+	return nil
+}
+
+// lineCount parses the -n option of head and tail, also accepting the
+// historical -N form. fromStart is true for "+N", which tail reads as
+// "start at line N".
+func lineCount(hc interp.HandlerContext, name string, args []string) (n int, fromStart bool, operands []string, err error) {
+	if len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if n, err := strconv.Atoi(args[0][1:]); err == nil {
+			return n, false, args[1:], nil
+		}
+	}
+	flags, operands, err := shortFlags(args, "", "n")
+	if err != nil {
+		return 0, false, nil, usageError(hc, name, err)
+	}
+	n = 10
+	if v, ok := flags['n']; ok {
+		fromStart = strings.HasPrefix(v, "+")
+		if n, err = strconv.Atoi(strings.TrimPrefix(v, "+")); err != nil || n < 0 {
+			return 0, false, nil, utilError(hc, name, "invalid number of lines: %q", v)
+		}
+	}
+	return n, fromStart, operands, nil
+}
+
+func utilHead(_ context.Context, hc interp.HandlerContext, args []string) error {
+	n, _, operands, err := lineCount(hc, "head", args[1:])
+	if err != nil {
+		return err
+	}
+	r, closeAll, err := openInputs(hc, operands)
+	if err != nil {
+		return utilError(hc, "head", "%v", err)
+	}
+	defer closeAll()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for i := 0; i < n && scanner.Scan(); i++ {
+		_, _ = fmt.Fprintln(hc.Stdout, scanner.Text())
+	}
+	return nil
+}
+
+func utilTail(_ context.Context, hc interp.HandlerContext, args []string) error {
+	n, fromStart, operands, err := lineCount(hc, "tail", args[1:])
+	if err != nil {
+		return err
+	}
+	r, closeAll, err := openInputs(hc, operands)
+	if err != nil {
+		return utilError(hc, "tail", "%v", err)
+	}
+	defer closeAll()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	if fromStart {
+		for line := 1; scanner.Scan(); line++ {
+			if line >= n {
+				_, _ = fmt.Fprintln(hc.Stdout, scanner.Text())
+			}
+		}
+		return nil
+	}
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	for _, line := range lines {
+		_, _ = fmt.Fprintln(hc.Stdout, line)
+	}
+	return nil
+}
+
+func utilWc(_ context.Context, hc interp.HandlerContext, args []string) error {
+	flags, operands, err := shortFlags(args[1:], "lwc", "")
+	if err != nil {
+		return usageError(hc, "wc", err)
+	}
+	r, closeAll, err := openInputs(hc, operands)
+	if err != nil {
+		return utilError(hc, "wc", "%v", err)
+	}
+	defer closeAll()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return utilError(hc, "wc", "%v", err)
+	}
+	counts := map[byte]int{
+		'l': bytes.Count(content, []byte("\n")),
+		'w': len(bytes.Fields(content)),
+		'c': len(content),
+	}
+	var fields []string
+	for _, f := range []byte("lwc") {
+		if len(flags) == 0 || hasFlag(flags, string(f)) {
+			fields = append(fields, strconv.Itoa(counts[f]))
+		}
+	}
+	_, _ = fmt.Fprintln(hc.Stdout, strings.Join(fields, " "))
+	return nil
+}
@@ -0,0 +1,136 @@
+package shell
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Dialect selects the shell language a script is parsed as.
+type Dialect string
+
+const (
+	// DialectAuto picks the dialect from the script's shebang,
+	// falling back to bash when there is none.
+	DialectAuto  Dialect = ""
+	DialectPOSIX Dialect = "posix"
+	DialectBash  Dialect = "bash"
+	DialectMksh  Dialect = "mksh"
+)
+
+// shebangDialects maps interpreter names to the dialect they speak.
+var shebangDialects = map[string]Dialect{
+	"sh":   DialectPOSIX,
+	"ash":  DialectPOSIX,
+	"dash": DialectPOSIX,
+	"posh": DialectPOSIX,
+	"bash": DialectBash,
+	"bats": DialectBash,
+	"zsh":  DialectBash,
+	"ksh":  DialectMksh,
+	"lksh": DialectMksh,
+	"mksh": DialectMksh,
+}
+
+// Shebang returns the interpreter named by a script's "#!" line, looking
+// through env. It returns "" if the script has no shebang.
+// For example, it returns "bash" for "#!/usr/bin/env bash".
+func Shebang(content string) string {
+	if !strings.HasPrefix(content, "#!") {
+		return ""
+	}
+	line, _, _ := strings.Cut(content[2:], "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	name := path.Base(fields[0])
+	if name == "env" {
+		name = ""
+		for _, f := range fields[1:] {
+			if strings.HasPrefix(f, "-") || strings.Contains(f, "=") {
+				continue
+			}
+			name = path.Base(f)
+			break
+		}
+	}
+	return name
+}
+
+// DetectDialect returns the dialect declared by a script's shebang,
+// or DialectAuto if there is no recognized shell shebang.
+func DetectDialect(content string) Dialect {
+	return shebangDialects[Shebang(content)]
+}
+
+// ParseDialect converts a name such as "sh", "posix", "bash" or "mksh"
+// to a Dialect.
+func ParseDialect(name string) (Dialect, error) {
+	switch name {
+	case "", "auto":
+		return DialectAuto, nil
+	case string(DialectPOSIX):
+		return DialectPOSIX, nil
+	}
+	if d, ok := shebangDialects[name]; ok {
+		return d, nil
+	}
+	return DialectAuto, fmt.Errorf("unknown shell dialect: %q", name)
+}
+
+// resolve returns the concrete dialect for a script.
+func (d Dialect) resolve(content string) Dialect {
+	if d != DialectAuto {
+		return d
+	}
+	if detected := DetectDialect(content); detected != DialectAuto {
+		return detected
+	}
+	return DialectBash
+}
+
+// lang returns the parser language variant for a concrete dialect.
+func (d Dialect) lang() syntax.LangVariant {
+	switch d {
+	case DialectPOSIX:
+		return syntax.LangPOSIX
+	case DialectMksh:
+		return syntax.LangMirBSDKorn
+	default:
+		return syntax.LangBash
+	}
+}
+
+// parseScript parses a script in the given dialect. In strict mode, the
+// script is parsed as POSIX and any bashisms the parser accepts are
+// rejected as well.
+func parseScript(script Script, dialect Dialect, strict bool) (*syntax.File, Dialect, error) {
+	dialect = dialect.resolve(script.Content)
+	if strict {
+		dialect = DialectPOSIX
+	}
+
+	parser := syntax.NewParser(syntax.Variant(dialect.lang()))
+	prog, err := parser.Parse(strings.NewReader(script.Content), script.Name)
+	if err != nil {
+		return nil, dialect, err
+	}
+
+	if strict {
+		a := newAnalyzer(Shebang(script.Content))
+		a.posix = true
+		syntax.Walk(prog, func(node syntax.Node) bool {
+			a.bashism(node)
+			return true
+		})
+		if len(a.analysis.Findings) > 0 {
+			f := a.analysis.Findings[0]
+			return nil, dialect, fmt.Errorf("%s:%d: %s", script.Name, f.Line, f.Detail)
+		}
+	}
+
+	return prog, dialect, nil
+}
@@ -0,0 +1,145 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestShebang(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{content: "#!/bin/sh\necho hi", want: "sh"},
+		{content: "#!/usr/bin/env bash\n", want: "bash"},
+		{content: "#! /bin/dash -e\n", want: "dash"},
+		{content: "#!/usr/bin/env -S mksh -x\n", want: "mksh"},
+		{content: "echo no shebang", want: ""},
+		{content: "#!", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := Shebang(tt.content); got != tt.want {
+			t.Errorf("Shebang(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestDetectDialect(t *testing.T) {
+	tests := []struct {
+		content string
+		want    Dialect
+	}{
+		{content: "#!/bin/sh\n", want: DialectPOSIX},
+		{content: "#!/usr/bin/env bash\n", want: DialectBash},
+		{content: "#!/bin/mksh\n", want: DialectMksh},
+		{content: "#!/usr/bin/python3\n", want: DialectAuto},
+		{content: "echo hi", want: DialectAuto},
+	}
+
+	for _, tt := range tests {
+		if got := DetectDialect(tt.content); got != tt.want {
+			t.Errorf("DetectDialect(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestParseDialect(t *testing.T) {
+	for name, want := range map[string]Dialect{"": DialectAuto, "sh": DialectPOSIX, "posix": DialectPOSIX, "bash": DialectBash, "mksh": DialectMksh} {
+		got, err := ParseDialect(name)
+		if err != nil {
+			t.Errorf("ParseDialect(%q) error: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("ParseDialect(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := ParseDialect("fish"); err == nil {
+		t.Error("ParseDialect(\"fish\") should fail")
+	}
+}
+
+func TestRunWithOptions_Dialect(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		opts    Options
+		wantErr bool
+	}{
+		{
+			name:    "bash shebang allows bashisms",
+			content: "#!/usr/bin/env bash\nif [[ a == a ]]; then echo ok; fi",
+		},
+		{
+			name:    "sh shebang rejects bashisms",
+			content: "#!/bin/sh\nlist=(a b)",
+			wantErr: true,
+		},
+		{
+			name:    "override to bash",
+			content: "#!/bin/sh\nif [[ a == a ]]; then echo ok; fi",
+			opts:    Options{Dialect: DialectBash},
+		},
+		{
+			name:    "no shebang defaults to bash",
+			content: "if [[ a == a ]]; then echo ok; fi",
+		},
+		{
+			name:    "strict rejects bash shebang bashisms",
+			content: "#!/usr/bin/env bash\nif [[ a == a ]]; then echo ok; fi",
+			opts:    Options{StrictPOSIX: true},
+			wantErr: true,
+		},
+		{
+			name:    "strict rejects source",
+			content: "#!/bin/sh\nsource ./lib.sh",
+			opts:    Options{StrictPOSIX: true},
+			wantErr: true,
+		},
+		{
+			name:    "strict rejects == in test",
+			content: "#!/bin/sh\n[ a == a ] && echo ok",
+			opts:    Options{StrictPOSIX: true},
+			wantErr: true,
+		},
+		{
+			name:    "strict allows posix",
+			content: "#!/bin/sh\nif [ a = a ]; then echo ok; fi",
+			opts:    Options{StrictPOSIX: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := log.New("test")
+			logger.SetOutput(&bytes.Buffer{})
+			script := Script{Content: tt.content, Name: "test.sh"}
+
+			result, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, tt.opts, logger)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.ParseError != tt.wantErr {
+				t.Errorf("ParseError = %v, want %v", result.ParseError, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRun_BashDefault(t *testing.T) {
+	logger := log.New("test")
+	logger.SetOutput(&bytes.Buffer{})
+	script := Script{Content: "#!/bin/sh\nfunction f { echo \"$1\"; }\nlist=(a b)\n[[ -n x ]] && f \"${list[1]}\"", Name: "test.sh"}
+
+	var stdout bytes.Buffer
+	if err := Run(context.Background(), script, nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, logger); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if stdout.String() != "b\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "b\n")
+	}
+}
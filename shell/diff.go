@@ -0,0 +1,329 @@
+package shell
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// DiffKind describes how part of a script changed between two versions.
+type DiffKind string
+
+const (
+	DiffAdded   DiffKind = "added"
+	DiffRemoved DiffKind = "removed"
+	DiffChanged DiffKind = "changed"
+)
+
+// ScriptDiff describes how a script changed between two versions, such
+// as a cached copy of an installer and the one just fetched. It is
+// computed from the scripts' syntax trees, so layout and comments are
+// ignored.
+type ScriptDiff struct {
+	// Equivalent is true if the scripts differ only in layout and
+	// comments. The other fields are then empty.
+	Equivalent bool
+
+	// OldShell and NewShell are the interpreters named by the scripts'
+	// shebangs, if they differ.
+	OldShell string
+	NewShell string
+
+	// Commands lists the simple commands and the headers of for and
+	// select loops added or removed, in script order, printed in
+	// canonical form. Loop headers include the words iterated over.
+	Commands []CommandDiff
+
+	// URLs lists the URLs added or removed, sorted.
+	URLs []URLDiff
+
+	// Vars lists the variables whose assigned values changed, sorted by
+	// name.
+	Vars []VarDiff
+}
+
+// CommandDiff is a simple command or loop header added to or removed
+// from a script.
+// Line is in the new script for added commands and in the old script for
+// removed ones.
+type CommandDiff struct {
+	Kind    DiffKind
+	Command string
+	Line    uint
+}
+
+// URLDiff is a URL added to or removed from a script.
+type URLDiff struct {
+	Kind DiffKind
+	URL  string
+}
+
+// VarDiff is a variable whose assignments changed. Old and New hold the
+// distinct values assigned to it, as written, separated by commas; one of
+// them is empty if the variable was added or removed.
+type VarDiff struct {
+	Kind DiffKind
+	Name string
+	Old  string
+	New  string
+}
+
+// scriptFacts is what Diff compares between two scripts.
+type scriptFacts struct {
+	canonical string
+	commands  []CommandDiff
+	vars      map[string][]string
+	urls      []string
+}
+
+// Diff compares two versions of a script.
+func Diff(oldScript, newScript Script) (*ScriptDiff, error) {
+	before, err := diffFacts(oldScript)
+	if err != nil {
+		return nil, err
+	}
+	after, err := diffFacts(newScript)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &ScriptDiff{}
+	if before.canonical == after.canonical {
+		d.Equivalent = true
+		return d, nil
+	}
+	if oldShell, newShell := Shebang(oldScript.Content), Shebang(newScript.Content); oldShell != newShell {
+		d.OldShell, d.NewShell = oldShell, newShell
+	}
+	d.Commands = diffCommands(before.commands, after.commands)
+	for _, u := range before.urls {
+		if !slices.Contains(after.urls, u) {
+			d.URLs = append(d.URLs, URLDiff{Kind: DiffRemoved, URL: u})
+		}
+	}
+	for _, u := range after.urls {
+		if !slices.Contains(before.urls, u) {
+			d.URLs = append(d.URLs, URLDiff{Kind: DiffAdded, URL: u})
+		}
+	}
+	slices.SortStableFunc(d.URLs, func(x, y URLDiff) int { return strings.Compare(x.URL, y.URL) })
+	d.Vars = diffVars(before.vars, after.vars)
+	return d, nil
+}
+
+func diffFacts(script Script) (*scriptFacts, error) {
+	prog, err := parseLenient(script)
+	if err != nil {
+		return nil, err
+	}
+	analysis, err := Analyze(script)
+	if err != nil {
+		return nil, err
+	}
+	facts := &scriptFacts{vars: map[string][]string{}, urls: analysis.URLs}
+
+	// Lines are noted before canonicalize discards the layout, and words
+	// printed after, so that layout doesn't matter.
+	var commands []syntax.Node
+	var assigns []*syntax.Assign
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.CallExpr:
+			if len(n.Args) > 0 {
+				commands = append(commands, n)
+				facts.commands = append(facts.commands, CommandDiff{Line: n.Pos().Line()})
+			}
+		case *syntax.ForClause:
+			if _, ok := n.Loop.(*syntax.WordIter); ok {
+				commands = append(commands, n)
+				facts.commands = append(facts.commands, CommandDiff{Line: n.Pos().Line()})
+			}
+		case *syntax.Assign:
+			if !n.Naked && n.Index == nil {
+				assigns = append(assigns, n)
+			}
+		}
+		return true
+	})
+	canonicalize(prog)
+
+	printer := syntax.NewPrinter()
+	printNode := func(node syntax.Node) string {
+		var sb strings.Builder
+		if err := printer.Print(&sb, node); err != nil {
+			return ""
+		}
+		return sb.String()
+	}
+	printWords := func(words []*syntax.Word) string {
+		printed := make([]string, len(words))
+		for i, w := range words {
+			printed[i] = printNode(w)
+		}
+		return strings.Join(printed, " ")
+	}
+	for i, node := range commands {
+		switch n := node.(type) {
+		case *syntax.CallExpr:
+			facts.commands[i].Command = printWords(n.Args)
+		case *syntax.ForClause:
+			iter := n.Loop.(*syntax.WordIter)
+			header := "for " + iter.Name.Value
+			if n.Select {
+				header = "select " + iter.Name.Value
+			}
+			if iter.InPos.IsValid() {
+				header += " in " + printWords(iter.Items)
+			}
+			facts.commands[i].Command = header
+		}
+	}
+	for _, as := range assigns {
+		var value string
+		switch {
+		case as.Array != nil:
+			value = printNode(as.Array)
+		case as.Value != nil:
+			value = printNode(as.Value)
+		}
+		if as.Append {
+			value = "+=" + value
+		}
+		if !slices.Contains(facts.vars[as.Name.Value], value) {
+			facts.vars[as.Name.Value] = append(facts.vars[as.Name.Value], value)
+		}
+	}
+	facts.canonical, err = printFormatted(script, printer, prog, true)
+	if err != nil {
+		return nil, err
+	}
+	return facts, nil
+}
+
+// diffCommands returns the commands removed from before and added in
+// after, from their longest common subsequence.
+func diffCommands(before, after []CommandDiff) []CommandDiff {
+	n, m := len(before), len(after)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if before[i].Command == after[j].Command {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diffs []CommandDiff
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && before[i].Command == after[j].Command:
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			c := before[i]
+			c.Kind = DiffRemoved
+			diffs = append(diffs, c)
+			i++
+		default:
+			c := after[j]
+			c.Kind = DiffAdded
+			diffs = append(diffs, c)
+			j++
+		}
+	}
+	return diffs
+}
+
+func diffVars(before, after map[string][]string) []VarDiff {
+	var diffs []VarDiff
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+	for _, name := range sortedKeys(names) {
+		oldValues, newValues := before[name], after[name]
+		if slices.Equal(oldValues, newValues) {
+			continue
+		}
+		d := VarDiff{Name: name, Old: strings.Join(oldValues, ", "), New: strings.Join(newValues, ", ")}
+		switch {
+		case oldValues == nil:
+			d.Kind = DiffAdded
+		case newValues == nil:
+			d.Kind = DiffRemoved
+		default:
+			d.Kind = DiffChanged
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// String renders a human-readable report of the changes, suitable for
+// telling a user that an installer changed since it last ran.
+func (d *ScriptDiff) String() string {
+	if d.Equivalent {
+		return "No changes other than layout and comments.\n"
+	}
+	var sb strings.Builder
+	if d.OldShell != d.NewShell {
+		fmt.Fprintf(&sb, "Shell: %s -> %s\n", orNone(d.OldShell), orNone(d.NewShell))
+	}
+	if len(d.Commands) > 0 {
+		sb.WriteString("Commands:\n")
+		for _, c := range d.Commands {
+			fmt.Fprintf(&sb, "  %s line %d: %s\n", diffMark(c.Kind), c.Line, c.Command)
+		}
+	}
+	if len(d.URLs) > 0 {
+		sb.WriteString("URLs:\n")
+		for _, u := range d.URLs {
+			fmt.Fprintf(&sb, "  %s %s\n", diffMark(u.Kind), u.URL)
+		}
+	}
+	if len(d.Vars) > 0 {
+		sb.WriteString("Variables:\n")
+		for _, v := range d.Vars {
+			switch v.Kind {
+			case DiffAdded:
+				fmt.Fprintf(&sb, "  + %s=%s\n", v.Name, v.New)
+			case DiffRemoved:
+				fmt.Fprintf(&sb, "  - %s=%s\n", v.Name, v.Old)
+			default:
+				fmt.Fprintf(&sb, "  ~ %s: %s -> %s\n", v.Name, v.Old, v.New)
+			}
+		}
+	}
+	if sb.Len() == 0 {
+		sb.WriteString("The script's control flow changed.\n")
+	}
+	return sb.String()
+}
+
+func diffMark(kind DiffKind) string {
+	switch kind {
+	case DiffAdded:
+		return "+"
+	case DiffRemoved:
+		return "-"
+	}
+	return "~"
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
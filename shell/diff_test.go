@@ -0,0 +1,146 @@
+package shell
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	before := Script{Name: "install.sh", Content: `#!/bin/sh
+set -e
+VERSION="1.2.0"
+PREFIX=/usr/local
+URL="https://example.com/tool-$VERSION.tar.gz"
+curl -fsSL "$URL" -o /tmp/tool.tar.gz
+tar -xzf /tmp/tool.tar.gz -C "$PREFIX"
+echo done
+`}
+	after := Script{Name: "install.sh", Content: `#!/bin/bash
+set -e
+# Bumped.
+VERSION="1.3.0"
+URL="https://mirror.example.net/tool-$VERSION.tar.gz"
+curl -fsSL "$URL" \
+	-o /tmp/tool.tar.gz
+tar -xzf /tmp/tool.tar.gz -C /opt
+sudo ln -s /opt/tool /usr/bin/tool
+echo done
+CHANNEL=stable
+`}
+
+	d, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("Diff() error: %v", err)
+	}
+	if d.Equivalent {
+		t.Fatal("Equivalent = true for different scripts")
+	}
+	if d.OldShell != "sh" || d.NewShell != "bash" {
+		t.Errorf("shells = %q, %q", d.OldShell, d.NewShell)
+	}
+
+	wantCommands := []CommandDiff{
+		{Kind: DiffRemoved, Command: `tar -xzf /tmp/tool.tar.gz -C "$PREFIX"`, Line: 7},
+		{Kind: DiffAdded, Command: "tar -xzf /tmp/tool.tar.gz -C /opt", Line: 8},
+		{Kind: DiffAdded, Command: "sudo ln -s /opt/tool /usr/bin/tool", Line: 9},
+	}
+	if !slices.Equal(d.Commands, wantCommands) {
+		t.Errorf("Commands = %+v, want %+v", d.Commands, wantCommands)
+	}
+
+	wantURLs := []URLDiff{
+		{Kind: DiffRemoved, URL: "https://example.com/tool-$VERSION.tar.gz"},
+		{Kind: DiffAdded, URL: "https://mirror.example.net/tool-$VERSION.tar.gz"},
+	}
+	if !slices.Equal(d.URLs, wantURLs) {
+		t.Errorf("URLs = %+v, want %+v", d.URLs, wantURLs)
+	}
+
+	wantVars := []VarDiff{
+		{Kind: DiffAdded, Name: "CHANNEL", New: "stable"},
+		{Kind: DiffRemoved, Name: "PREFIX", Old: "/usr/local"},
+		{Kind: DiffChanged, Name: "URL", Old: `"https://example.com/tool-$VERSION.tar.gz"`, New: `"https://mirror.example.net/tool-$VERSION.tar.gz"`},
+		{Kind: DiffChanged, Name: "VERSION", Old: `"1.2.0"`, New: `"1.3.0"`},
+	}
+	if !slices.Equal(d.Vars, wantVars) {
+		t.Errorf("Vars = %+v, want %+v", d.Vars, wantVars)
+	}
+
+	want := `Shell: sh -> bash
+Commands:
+  - line 7: tar -xzf /tmp/tool.tar.gz -C "$PREFIX"
+  + line 8: tar -xzf /tmp/tool.tar.gz -C /opt
+  + line 9: sudo ln -s /opt/tool /usr/bin/tool
+URLs:
+  - https://example.com/tool-$VERSION.tar.gz
+  + https://mirror.example.net/tool-$VERSION.tar.gz
+Variables:
+  + CHANNEL=stable
+  - PREFIX=/usr/local
+  ~ URL: "https://example.com/tool-$VERSION.tar.gz" -> "https://mirror.example.net/tool-$VERSION.tar.gz"
+  ~ VERSION: "1.2.0" -> "1.3.0"
+`
+	if got := d.String(); got != want {
+		t.Errorf("String() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDiff_Equivalent(t *testing.T) {
+	tests := []struct {
+		name       string
+		before     string
+		after      string
+		equivalent bool
+		report     string
+	}{
+		{
+			name:       "layout and comments",
+			before:     "#!/bin/sh\n# setup\nif true; then echo a; fi\n",
+			after:      "#!/bin/sh\nif true\nthen\n    echo a   # say a\nfi\n",
+			equivalent: true,
+			report:     "No changes other than layout and comments.\n",
+		},
+		{
+			name:   "control flow",
+			before: "if true; then echo a; fi\n",
+			after:  "if false; then echo a; fi\n",
+			report: "Commands:\n  - line 1: true\n  + line 1: false\n",
+		},
+		{
+			name:   "loop words",
+			before: "for f in /usr/local/bin/tool; do rm -f \"$f\"; done\n",
+			after:  "for f in / /home; do rm -f \"$f\"; done\n",
+			report: "Commands:\n  - line 1: for f in /usr/local/bin/tool\n  + line 1: for f in / /home\n",
+		},
+		{
+			name:   "select words",
+			before: "select x in a b; do break; done\n",
+			after:  "select x; do break; done\n",
+			report: "Commands:\n  - line 1: select x in a b\n  + line 1: select x\n",
+		},
+		{
+			name:   "structure only",
+			before: "a && b\n",
+			after:  "a || b\n",
+			report: "The script's control flow changed.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Diff(Script{Name: "old.sh", Content: tt.before}, Script{Name: "new.sh", Content: tt.after})
+			if err != nil {
+				t.Fatalf("Diff() error: %v", err)
+			}
+			if d.Equivalent != tt.equivalent {
+				t.Errorf("Equivalent = %v, want %v", d.Equivalent, tt.equivalent)
+			}
+			if got := d.String(); got != tt.report {
+				t.Errorf("String() = %q, want %q", got, tt.report)
+			}
+		})
+	}
+
+	if _, err := Diff(Script{Name: "old.sh", Content: "echo"}, Script{Name: "new.sh", Content: "if"}); err == nil {
+		t.Error("Diff() of invalid script succeeded")
+	}
+}
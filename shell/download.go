@@ -0,0 +1,336 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/installable-sh/lib/log"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// download is a curl or wget invocation using the supported flag subset.
+type download struct {
+	url        string
+	output     string // "" for the tool's default, "-" for stdout
+	remoteName bool
+	createDirs bool
+	header     http.Header
+	fail       bool
+	quiet      bool
+	showError  bool
+}
+
+// errUnsupportedFlag means the invocation must be left to the real binary.
+type errUnsupportedFlag string
+
+func (e errUnsupportedFlag) Error() string {
+	return fmt.Sprintf("unsupported option: %s", string(e))
+}
+
+// downloadMiddleware serves curl and wget with the given HTTP client, so
+// that downloads made by scripts use the same certificates, retries and
+// proxy settings as the fetch package. Invocations using flags outside
+// the supported subset fall through to the real binary.
+func downloadMiddleware(client *retryablehttp.Client, logger log.DebugLogger) func(interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	client = passthroughErrors(client)
+	return func(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+		return func(ctx context.Context, args []string) error {
+			var d *download
+			var err error
+			switch args[0] {
+			case "curl":
+				d, err = parseCurl(args[1:])
+			case "wget":
+				d, err = parseWget(args[1:])
+			default:
+				return next(ctx, args)
+			}
+			hc := execCtx(ctx)
+			if err != nil {
+				if _, ok := err.(errUnsupportedFlag); ok {
+					logger.Debugf("%s: %v, using external binary", args[0], err)
+					return next(ctx, args)
+				}
+				_, _ = fmt.Fprintf(hc.Stderr, "%s: %v\n", args[0], err)
+				return interp.ExitStatus(2)
+			}
+			return d.run(ctx, hc, args[0], client, logger)
+		}
+	}
+}
+
+// passthroughErrors returns a copy of client that returns the last
+// response once retries are exhausted, rather than an error, so that
+// server errors are handled like the tools do.
+func passthroughErrors(client *retryablehttp.Client) *retryablehttp.Client {
+	return &retryablehttp.Client{
+		HTTPClient:      client.HTTPClient,
+		Logger:          client.Logger,
+		RetryWaitMin:    client.RetryWaitMin,
+		RetryWaitMax:    client.RetryWaitMax,
+		RetryMax:        client.RetryMax,
+		RequestLogHook:  client.RequestLogHook,
+		ResponseLogHook: client.ResponseLogHook,
+		CheckRetry:      client.CheckRetry,
+		Backoff:         client.Backoff,
+		ErrorHandler:    retryablehttp.PassthroughErrorHandler,
+		PrepareRetry:    client.PrepareRetry,
+	}
+}
+
+func parseCurl(args []string) (*download, error) {
+	d := &download{header: http.Header{}}
+	d.header.Set("User-Agent", "curl/8.0 (installable)")
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		value := func() (string, error) {
+			if len(args) == 0 {
+				return "", fmt.Errorf("option %s: requires parameter", arg)
+			}
+			v := args[0]
+			args = args[1:]
+			return v, nil
+		}
+		var err error
+		switch {
+		case arg == "--fail" || arg == "--fail-with-body":
+			d.fail = true
+		case arg == "--silent":
+			d.quiet = true
+		case arg == "--show-error":
+			d.showError = true
+		case arg == "--location" || arg == "--compressed" || arg == "--progress-bar":
+			// Redirects are always followed and responses decompressed.
+		case arg == "--create-dirs":
+			d.createDirs = true
+		case arg == "--remote-name":
+			d.remoteName = true
+		case arg == "--output":
+			d.output, err = value()
+		case arg == "--header":
+			var h string
+			if h, err = value(); err == nil {
+				err = addHeader(d.header, h)
+			}
+		case arg == "--user-agent":
+			var ua string
+			if ua, err = value(); err == nil {
+				d.header.Set("User-Agent", ua)
+			}
+		case arg == "--proto" || arg == "--retry" || arg == "--connect-timeout" || arg == "--max-time":
+			// Retries and timeouts come from the client.
+			_, err = value()
+		case arg == "--tlsv1.2" || arg == "--tlsv1.3" || arg == "-#":
+		case strings.HasPrefix(arg, "--"):
+			return nil, errUnsupportedFlag(arg)
+		case len(arg) > 1 && arg[0] == '-':
+			for i := 1; i < len(arg); i++ {
+				switch c := arg[i]; c {
+				case 'f':
+					d.fail = true
+				case 's':
+					d.quiet = true
+				case 'S':
+					d.showError = true
+				case 'L', '#':
+				case 'O':
+					d.remoteName = true
+				case 'o', 'H', 'A':
+					v := arg[i+1:]
+					if v == "" {
+						if v, err = value(); err != nil {
+							return nil, err
+						}
+					}
+					switch c {
+					case 'o':
+						d.output = v
+					case 'H':
+						err = addHeader(d.header, v)
+					case 'A':
+						d.header.Set("User-Agent", v)
+					}
+					i = len(arg)
+				default:
+					return nil, errUnsupportedFlag("-" + string(c))
+				}
+			}
+		default:
+			if d.url != "" {
+				return nil, errUnsupportedFlag("multiple URLs")
+			}
+			d.url = arg
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.url == "" {
+		return nil, fmt.Errorf("no URL specified")
+	}
+	if d.output == "" && !d.remoteName {
+		d.output = "-"
+	}
+	return d, nil
+}
+
+func parseWget(args []string) (*download, error) {
+	d := &download{header: http.Header{}, fail: true, showError: true}
+	d.header.Set("User-Agent", "Wget/1.21 (installable)")
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		var err error
+		switch {
+		case arg == "--quiet":
+			d.quiet = true
+		case strings.HasPrefix(arg, "--output-document="):
+			d.output = strings.TrimPrefix(arg, "--output-document=")
+		case strings.HasPrefix(arg, "--header="):
+			err = addHeader(d.header, strings.TrimPrefix(arg, "--header="))
+		case strings.HasPrefix(arg, "--user-agent="):
+			d.header.Set("User-Agent", strings.TrimPrefix(arg, "--user-agent="))
+		case arg == "--no-verbose" || arg == "--https-only" || strings.HasPrefix(arg, "--tries=") || strings.HasPrefix(arg, "--timeout="):
+		case strings.HasPrefix(arg, "--"):
+			return nil, errUnsupportedFlag(arg)
+		case len(arg) > 1 && arg[0] == '-':
+			for i := 1; i < len(arg); i++ {
+				switch c := arg[i]; c {
+				case 'q', 'n':
+					d.quiet = true
+				case 'v':
+				case 'O', 'U':
+					v := arg[i+1:]
+					if v == "" {
+						if len(args) == 0 {
+							return nil, fmt.Errorf("option requires an argument -- '%c'", c)
+						}
+						v, args = args[0], args[1:]
+					}
+					if c == 'O' {
+						d.output = v
+					} else {
+						d.header.Set("User-Agent", v)
+					}
+					i = len(arg)
+				default:
+					return nil, errUnsupportedFlag("-" + string(c))
+				}
+			}
+		default:
+			if d.url != "" {
+				return nil, errUnsupportedFlag("multiple URLs")
+			}
+			d.url = arg
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.url == "" {
+		return nil, fmt.Errorf("missing URL")
+	}
+	if d.output == "" {
+		d.remoteName = true
+	}
+	return d, nil
+}
+
+func addHeader(h http.Header, value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("invalid header: %q", value)
+	}
+	h.Set(strings.TrimSpace(name), strings.TrimSpace(val))
+	return nil
+}
+
+// remoteFileName returns the file name curl -O and wget use for a URL.
+func remoteFileName(rawURL string) string {
+	name := "index.html"
+	if u, err := url.Parse(rawURL); err == nil {
+		if base := path.Base(u.Path); base != "" && base != "/" && base != "." {
+			name = base
+		}
+	}
+	return name
+}
+
+func (d *download) run(ctx context.Context, hc interp.HandlerContext, tool string, client *retryablehttp.Client, logger log.DebugLogger) error {
+	// Exit codes follow the tools: curl uses 7 for connection failures
+	// and 22 for HTTP errors with --fail; wget uses 4 and 8.
+	connFailed, httpFailed := interp.ExitStatus(7), interp.ExitStatus(22)
+	if tool == "wget" {
+		connFailed, httpFailed = interp.ExitStatus(4), interp.ExitStatus(8)
+	}
+	report := func(format string, args ...any) {
+		if !d.quiet || d.showError {
+			_, _ = fmt.Fprintf(hc.Stderr, "%s: %s\n", tool, fmt.Sprintf(format, args...))
+		}
+	}
+
+	logger.Debugf("Serving %s in-process: %s", tool, d.url)
+	req, err := retryablehttp.NewRequestWithContext(ctx, "GET", d.url, nil)
+	if err != nil {
+		report("%v", err)
+		return interp.ExitStatus(3)
+	}
+	req.Header = d.header
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		report("%v", err)
+		return connFailed
+	}
+	defer func() { _ = resp.Body.Close() }()
+	logger.Debugf("%s: HTTP %d from %s", tool, resp.StatusCode, d.url)
+
+	if d.fail && resp.StatusCode >= 400 {
+		report("The requested URL returned error: %d", resp.StatusCode)
+		return httpFailed
+	}
+
+	output := d.output
+	if d.remoteName && output == "" {
+		output = remoteFileName(d.url)
+	}
+
+	var w io.Writer = hc.Stdout
+	if output != "-" {
+		p := absPath(hc, output)
+		trackWrite(ctx, p)
+		if d.createDirs {
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				report("%v", err)
+				return interp.ExitStatus(23)
+			}
+		}
+		f, err := os.Create(p)
+		if err != nil {
+			report("%v", err)
+			return interp.ExitStatus(23)
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		report("%v", err)
+		return interp.ExitStatus(23)
+	}
+	logger.Debugf("%s: wrote %d bytes to %s", tool, n, output)
+	return nil
+}
@@ -0,0 +1,169 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/fetch"
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_Download(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hello.sh":
+			_, _ = w.Write([]byte("echo downloaded"))
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("down"))
+		case "/header":
+			_, _ = w.Write([]byte(r.Header.Get("X-Token")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	logger := log.New("test")
+	client, err := fetch.NewClient(logger)
+	if err != nil {
+		t.Fatalf("NewClient() error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		content    string
+		wantStdout string
+		wantErr    bool
+	}{
+		{
+			name:       "curl pipe to sh",
+			content:    `curl -fsSL "$1/hello.sh" | sh`,
+			wantStdout: "downloaded\n",
+		},
+		{
+			name:       "curl output file",
+			content:    `curl -fsSL -o "$2/out.sh" "$1/hello.sh" && cat "$2/out.sh"`,
+			wantStdout: "echo downloaded",
+		},
+		{
+			name:       "curl header",
+			content:    `curl -s -H 'X-Token: secret' "$1/header"`,
+			wantStdout: "secret",
+		},
+		{
+			name:    "curl fail",
+			content: `curl -fsS "$1/missing"`,
+			wantErr: true,
+		},
+		{
+			name:       "curl fail on server error",
+			content:    `curl -fsSL "$1/unavailable"; echo $?`,
+			wantStdout: "22\n",
+		},
+		{
+			name:       "curl server error body",
+			content:    `curl -sS "$1/unavailable"`,
+			wantStdout: "down",
+		},
+		{
+			name:       "wget to stdout",
+			content:    `wget -qO- "$1/hello.sh"`,
+			wantStdout: "echo downloaded",
+		},
+		{
+			name:       "wget remote name",
+			content:    `cd "$2" && wget -q "$1/hello.sh" && cat hello.sh`,
+			wantStdout: "echo downloaded",
+		},
+		{
+			name:    "wget fail",
+			content: `wget -q "$1/missing"`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			script := Script{Content: tt.content, Name: "test.sh"}
+			args := []string{server.URL, t.TempDir()}
+
+			_, err := RunWithOptions(context.Background(), script, args, strings.NewReader(""), &stdout, &stderr, Options{HTTPClient: client}, logger)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunWithOptions() error = %v, wantErr %v, stderr: %s", err, tt.wantErr, stderr.String())
+			}
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", got, tt.wantStdout)
+			}
+		})
+	}
+}
+
+func TestParseCurl(t *testing.T) {
+	d, err := parseCurl([]string{"-fsSLo", "out", "-H", "A: b", "https://example.com/x"})
+	if err != nil {
+		t.Fatalf("parseCurl() error: %v", err)
+	}
+	if !d.fail || !d.quiet || !d.showError {
+		t.Errorf("flags not parsed: %+v", d)
+	}
+	if d.output != "out" {
+		t.Errorf("output = %q, want %q", d.output, "out")
+	}
+	if d.header.Get("A") != "b" {
+		t.Errorf("header A = %q, want %q", d.header.Get("A"), "b")
+	}
+
+	if _, err := parseCurl([]string{"-X", "POST", "https://example.com"}); err == nil {
+		t.Error("parseCurl() should reject unsupported flags")
+	} else if _, ok := err.(errUnsupportedFlag); !ok {
+		t.Errorf("error should be errUnsupportedFlag, got %T", err)
+	}
+}
+
+func TestRemoteFileName(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/install.sh":       "install.sh",
+		"https://example.com/dl/tool.tar.gz?x": "tool.tar.gz",
+		"https://example.com/":                 "index.html",
+	}
+	for in, want := range tests {
+		if got := remoteFileName(in); got != want {
+			t.Errorf("remoteFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDownload_CreateDirs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	logger := log.New("test")
+	client, err := fetch.NewClient(logger)
+	if err != nil {
+		t.Fatalf("NewClient() error: %v", err)
+	}
+
+	dir := t.TempDir()
+	script := Script{Content: `curl -s --create-dirs -o "$2/a/b/file" "$1"`, Name: "test.sh"}
+	_, err = RunWithOptions(context.Background(), script, []string{server.URL, dir}, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{HTTPClient: client}, logger)
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "a", "b", "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "data" {
+		t.Errorf("content = %q, want %q", content, "data")
+	}
+}
@@ -0,0 +1,126 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// EnvChanges describes how a script changed the exported environment
+// and which functions it defined.
+type EnvChanges struct {
+	// Set holds exported variables that were added or changed.
+	Set map[string]string
+
+	// Unset lists variables that were exported at the start but are no
+	// longer set or exported.
+	Unset []string
+
+	// Funcs maps the functions left defined to their source code.
+	Funcs map[string]string
+}
+
+// Empty returns true if nothing changed.
+func (c *EnvChanges) Empty() bool {
+	return len(c.Set) == 0 && len(c.Unset) == 0 && len(c.Funcs) == 0
+}
+
+// Apply returns env, in os.Environ form, with the changes applied. It is
+// suitable as exec.Cmd.Env for commands that should see the environment
+// the script set up.
+func (c *EnvChanges) Apply(env []string) []string {
+	var out []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, ok := c.Set[name]; ok || slices.Contains(c.Unset, name) {
+			continue
+		}
+		out = append(out, kv)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Set)) {
+		out = append(out, name+"="+c.Set[name])
+	}
+	return out
+}
+
+// Profile renders the changes as POSIX shell code, suitable for appending
+// to a profile file such as ~/.profile.
+func (c *EnvChanges) Profile() string {
+	var sb strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(c.Set)) {
+		fmt.Fprintf(&sb, "export %s=%s\n", name, shellQuote(c.Set[name]))
+	}
+	for _, name := range c.Unset {
+		fmt.Fprintf(&sb, "unset %s\n", name)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Funcs)) {
+		sb.WriteString(c.Funcs[name])
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	q, err := syntax.Quote(s, syntax.LangPOSIX)
+	if err != nil {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	return q
+}
+
+// captureStartEnv records the exported variables the interpreter starts
+// with. It runs an empty program so that the runner's variables reflect
+// its initial state, including the ones it sets itself such as PWD.
+func (in *interpreter) captureStartEnv() error {
+	if err := in.runner.Run(context.Background(), &syntax.File{}); err != nil {
+		return err
+	}
+	in.startEnv = exportedVars(in.runner.Vars)
+	return nil
+}
+
+// exportedVars returns the values of the set, exported variables.
+func exportedVars(vars map[string]expand.Variable) map[string]string {
+	env := map[string]string{}
+	for name, vr := range vars {
+		if vr.Exported && vr.IsSet() {
+			env[name] = vr.String()
+		}
+	}
+	return env
+}
+
+// envChanges diffs the interpreter's current state against the
+// environment it started with.
+func (in *interpreter) envChanges() *EnvChanges {
+	changes := &EnvChanges{Set: map[string]string{}, Funcs: map[string]string{}}
+	final := exportedVars(in.runner.Vars)
+	for name, value := range final {
+		if old, ok := in.startEnv[name]; !ok || old != value {
+			changes.Set[name] = value
+		}
+	}
+	for name := range in.startEnv {
+		if _, ok := final[name]; !ok {
+			changes.Unset = append(changes.Unset, name)
+		}
+	}
+	slices.Sort(changes.Unset)
+
+	printer := syntax.NewPrinter()
+	for name, body := range in.runner.Funcs {
+		var sb strings.Builder
+		sb.WriteString(name + "() ")
+		if err := printer.Print(&sb, body); err != nil {
+			continue
+		}
+		changes.Funcs[name] = sb.String()
+	}
+	return changes
+}
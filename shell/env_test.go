@@ -0,0 +1,88 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_CaptureEnv(t *testing.T) {
+	t.Setenv("INSTALLABLE_KEEP", "same")
+	t.Setenv("INSTALLABLE_DROP", "gone")
+
+	script := Script{Name: "activate.sh", Content: `
+export PATH="/opt/tool/bin:$PATH"
+export TOOL_HOME='/opt/tool'
+LOCAL_ONLY=1
+unset INSTALLABLE_DROP
+tool() { echo "tool $*"; }`}
+
+	result, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{CaptureEnv: true}, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	env := result.Env
+	if env == nil {
+		t.Fatal("Result.Env is nil")
+	}
+
+	if got := env.Set["TOOL_HOME"]; got != "/opt/tool" {
+		t.Errorf("Set[TOOL_HOME] = %q, want %q", got, "/opt/tool")
+	}
+	if got := env.Set["PATH"]; !strings.HasPrefix(got, "/opt/tool/bin:") {
+		t.Errorf("Set[PATH] = %q, want /opt/tool/bin prefix", got)
+	}
+	for _, name := range []string{"LOCAL_ONLY", "INSTALLABLE_KEEP"} {
+		if _, ok := env.Set[name]; ok {
+			t.Errorf("Set contains %s", name)
+		}
+	}
+	if want := []string{"INSTALLABLE_DROP"}; !slices.Equal(env.Unset, want) {
+		t.Errorf("Unset = %v, want %v", env.Unset, want)
+	}
+	if got := env.Funcs["tool"]; !strings.HasPrefix(got, "tool() {") || !strings.Contains(got, `echo "tool $*"`) {
+		t.Errorf("Funcs[tool] = %q", got)
+	}
+}
+
+func TestRunWithOptions_CaptureEnvDisabled(t *testing.T) {
+	script := Script{Name: "test.sh", Content: "export FOO=bar"}
+	result, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{}, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	if result.Env != nil {
+		t.Errorf("Result.Env = %+v, want nil", result.Env)
+	}
+}
+
+func TestEnvChanges_Apply(t *testing.T) {
+	changes := &EnvChanges{
+		Set:   map[string]string{"PATH": "/opt/bin:/usr/bin", "NEW": "1"},
+		Unset: []string{"OLD"},
+	}
+	got := changes.Apply([]string{"HOME=/root", "PATH=/usr/bin", "OLD=x"})
+	want := []string{"HOME=/root", "NEW=1", "PATH=/opt/bin:/usr/bin"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Apply() = %v, want %v", got, want)
+	}
+}
+
+func TestEnvChanges_Profile(t *testing.T) {
+	changes := &EnvChanges{
+		Set:   map[string]string{"B": "it's", "A": "/opt/bin"},
+		Unset: []string{"OLD"},
+		Funcs: map[string]string{"f": "f() {\n\techo f\n}"},
+	}
+	want := "export A=/opt/bin\nexport B=\"it's\"\nunset OLD\nf() {\n\techo f\n}\n"
+	if got := changes.Profile(); got != want {
+		t.Errorf("Profile() = %q, want %q", got, want)
+	}
+	if !(&EnvChanges{}).Empty() {
+		t.Error("Empty() = false for no changes")
+	}
+}
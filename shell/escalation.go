@@ -0,0 +1,228 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/installable-sh/lib/log"
+	"golang.org/x/term"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// EscalationMode controls how sudo and doas invocations inside a script
+// are handled.
+type EscalationMode string
+
+const (
+	// EscalationPassthrough runs sudo and doas as written.
+	EscalationPassthrough EscalationMode = ""
+
+	// EscalationAuto strips the escalation when already root. Otherwise,
+	// when stdin is not a terminal, it passes -n so that a password
+	// prompt fails fast instead of hanging. doas is substituted when sudo
+	// is not installed.
+	EscalationAuto EscalationMode = "auto"
+
+	// EscalationDeny strips the escalation when already root and fails
+	// the command otherwise.
+	EscalationDeny EscalationMode = "deny"
+
+	// EscalationDoas strips the escalation when already root and runs
+	// sudo invocations through doas otherwise.
+	EscalationDoas EscalationMode = "doas"
+)
+
+// EscalationHook runs a command that a script asked to run with elevated
+// privileges. args excludes sudo or doas and their options; environment
+// assignments such as sudo FOO=bar cmd are passed as env FOO=bar cmd. The
+// interpreter's stdio and environment are available via interp.HandlerCtx.
+type EscalationHook func(ctx context.Context, args []string) error
+
+// escalation is a sudo or doas invocation split into its parts. env
+// holds NAME=value words given before the command, as sudo allows.
+type escalation struct {
+	tool  string
+	flags []string
+	user  string
+	env   []string
+	args  []string
+}
+
+// escalationValueFlags lists sudo and doas options that take a value.
+var escalationValueFlags = map[string]bool{
+	"-u": true, "-g": true, "-C": true, "-D": true, "-h": true,
+	"-p": true, "-r": true, "-t": true, "-T": true, "-U": true,
+}
+
+func parseEscalation(args []string) escalation {
+	e := escalation{tool: args[0]}
+	rest := args[1:]
+	for len(rest) > 0 {
+		arg := rest[0]
+		if arg == "--" {
+			rest = rest[1:]
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		e.flags = append(e.flags, arg)
+		rest = rest[1:]
+		if len(arg) > 2 && arg[1] != '-' && escalationValueFlags[arg[:2]] {
+			// The value is attached, as in -ubob.
+			if arg[:2] == "-u" {
+				e.user = arg[2:]
+			}
+			continue
+		}
+		if escalationValueFlags[arg] && len(rest) > 0 {
+			if arg == "-u" {
+				e.user = rest[0]
+			}
+			e.flags = append(e.flags, rest[0])
+			rest = rest[1:]
+		}
+	}
+	for len(rest) > 0 && isAssignment(rest[0]) {
+		e.env = append(e.env, rest[0])
+		rest = rest[1:]
+	}
+	e.args = rest
+	return e
+}
+
+// isAssignment reports whether word is a NAME=value environment
+// assignment.
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	return ok && syntax.ValidName(name)
+}
+
+// command returns the command to run with escalated privileges. Any
+// environment assignments are applied through env, since neither the
+// interpreter nor doas accept them in front of a command.
+func (e escalation) command() []string {
+	if len(e.env) == 0 {
+		return e.args
+	}
+	args := append([]string{"env"}, e.env...)
+	return append(args, e.args...)
+}
+
+// escalator applies an EscalationMode and records escalated commands.
+type escalator struct {
+	script   *Script
+	mode     EscalationMode
+	hook     EscalationHook
+	logger   log.DebugLogger
+	isRoot   bool
+	commands []Command
+	mu       sync.Mutex
+}
+
+func newEscalator(script *Script, mode EscalationMode, hook EscalationHook, logger log.DebugLogger) *escalator {
+	return &escalator{
+		script: script,
+		mode:   mode,
+		hook:   hook,
+		logger: logger,
+		isRoot: os.Geteuid() == 0,
+	}
+}
+
+func (e *escalator) exec(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		if args[0] != "sudo" && args[0] != "doas" {
+			return next(ctx, args)
+		}
+		esc := parseEscalation(args)
+		if len(esc.args) == 0 {
+			// Interactive shells such as sudo -i are left alone.
+			return next(ctx, args)
+		}
+		err := e.run(ctx, next, args, esc)
+		e.record(ctx, esc.command(), err)
+		return err
+	}
+}
+
+func (e *escalator) run(ctx context.Context, next interp.ExecHandlerFunc, args []string, esc escalation) error {
+	hc := execCtx(ctx)
+	asRoot := esc.user == "" || esc.user == "root"
+
+	if e.hook != nil {
+		e.logger.Debugf("Escalating via hook: %v", esc.command())
+		return e.hook(ctx, esc.command())
+	}
+	if e.mode == EscalationPassthrough {
+		return next(ctx, args)
+	}
+	if e.isRoot && asRoot {
+		e.logger.Debugf("Already root, running without %s: %v", esc.tool, esc.command())
+		return next(ctx, esc.command())
+	}
+
+	switch e.mode {
+	case EscalationDeny:
+		_, _ = fmt.Fprintf(hc.Stderr, "%s: privilege escalation is not allowed\n", esc.tool)
+		return interp.ExitStatus(1)
+	case EscalationDoas:
+		return next(ctx, esc.doas())
+	case EscalationAuto:
+		if esc.tool == "sudo" && !onPath(hc, "sudo") && onPath(hc, "doas") {
+			e.logger.Debugf("sudo not found, substituting doas")
+			args = esc.doas()
+		}
+		if !isTerminal(hc.Stdin) {
+			e.logger.Debugf("Non-interactive, running %s with -n", esc.tool)
+			args = append([]string{args[0], "-n"}, args[1:]...)
+		}
+		return next(ctx, args)
+	}
+	return next(ctx, args)
+}
+
+// doas returns the invocation rewritten for doas, keeping only the
+// target user since other sudo options have no doas equivalent.
+func (e escalation) doas() []string {
+	args := []string{"doas"}
+	if e.user != "" {
+		args = append(args, "-u", e.user)
+	}
+	return append(args, e.command()...)
+}
+
+func (e *escalator) record(ctx context.Context, args []string, err error) {
+	hc := execCtx(ctx)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.commands = append(e.commands, Command{
+		Args:     append([]string(nil), args...),
+		File:     e.script.Name,
+		Line:     hc.Pos.Line(),
+		ExitCode: exitCode(err),
+	})
+}
+
+// escalated returns the commands escalated since the last call.
+func (e *escalator) escalated() []Command {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	commands := e.commands
+	e.commands = nil
+	return commands
+}
+
+func onPath(hc interp.HandlerContext, name string) bool {
+	_, err := interp.LookPathDir(hc.Dir, hc.Env, name)
+	return err == nil
+}
+
+func isTerminal(r any) bool {
+	f, ok := r.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
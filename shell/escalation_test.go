@@ -0,0 +1,177 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+	"mvdan.cc/sh/v3/interp"
+)
+
+func TestParseEscalation(t *testing.T) {
+	esc := parseEscalation([]string{"sudo", "-E", "-u", "app", "--", "make", "install"})
+	if esc.tool != "sudo" {
+		t.Errorf("tool = %q, want %q", esc.tool, "sudo")
+	}
+	if esc.user != "app" {
+		t.Errorf("user = %q, want %q", esc.user, "app")
+	}
+	if !slices.Equal(esc.args, []string{"make", "install"}) {
+		t.Errorf("args = %v, want [make install]", esc.args)
+	}
+	if got := esc.doas(); !slices.Equal(got, []string{"doas", "-u", "app", "make", "install"}) {
+		t.Errorf("doas() = %v", got)
+	}
+
+	esc = parseEscalation([]string{"sudo", "-ubob", "FOO=bar", "sh", "-c", "echo $FOO"})
+	if esc.user != "bob" {
+		t.Errorf("user = %q, want %q", esc.user, "bob")
+	}
+	if !slices.Equal(esc.env, []string{"FOO=bar"}) || !slices.Equal(esc.args, []string{"sh", "-c", "echo $FOO"}) {
+		t.Errorf("env = %v, args = %v, want [FOO=bar] [sh -c echo $FOO]", esc.env, esc.args)
+	}
+	if got := esc.doas(); !slices.Equal(got, []string{"doas", "-u", "bob", "env", "FOO=bar", "sh", "-c", "echo $FOO"}) {
+		t.Errorf("doas() = %v", got)
+	}
+}
+
+func TestRunWithOptions_EscalationHook(t *testing.T) {
+	var calls [][]string
+	hook := func(ctx context.Context, args []string) error {
+		calls = append(calls, args)
+		hc := interp.HandlerCtx(ctx)
+		_, _ = fmt.Fprintf(hc.Stdout, "escalated: %s\n", strings.Join(args, " "))
+		return nil
+	}
+
+	script := Script{
+		Content: "sudo -n install -m 755 tool /usr/local/bin\ndoas true",
+		Name:    "install.sh",
+	}
+	var stdout bytes.Buffer
+	result, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, Options{EscalationHook: hook}, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+
+	want := "escalated: install -m 755 tool /usr/local/bin\nescalated: true\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("hook called %d times, want 2", len(calls))
+	}
+	if len(result.Escalations) != 2 {
+		t.Fatalf("Escalations = %d, want 2", len(result.Escalations))
+	}
+	if e := result.Escalations[0]; e.Line != 1 || e.File != "install.sh" || e.Args[0] != "install" {
+		t.Errorf("Escalations[0] = %+v", e)
+	}
+}
+
+func TestEscalator_Modes(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     EscalationMode
+		isRoot   bool
+		args     []string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{
+			name:     "passthrough",
+			mode:     EscalationPassthrough,
+			args:     []string{"sudo", "id"},
+			wantArgs: []string{"sudo", "id"},
+		},
+		{
+			name:     "strip when root",
+			mode:     EscalationAuto,
+			isRoot:   true,
+			args:     []string{"sudo", "-E", "id"},
+			wantArgs: []string{"id"},
+		},
+		{
+			name:     "strip with environment when root",
+			mode:     EscalationAuto,
+			isRoot:   true,
+			args:     []string{"sudo", "FOO=bar", "id"},
+			wantArgs: []string{"env", "FOO=bar", "id"},
+		},
+		{
+			name:    "keep attached other user when root",
+			mode:    EscalationDeny,
+			isRoot:  true,
+			args:    []string{"sudo", "-uapp", "id"},
+			wantErr: true,
+		},
+		{
+			name:    "keep other user when root",
+			mode:    EscalationDeny,
+			isRoot:  true,
+			args:    []string{"sudo", "-u", "app", "id"},
+			wantErr: true,
+		},
+		{
+			name:     "auto non-interactive",
+			mode:     EscalationAuto,
+			args:     []string{"sudo", "id"},
+			wantArgs: []string{"sudo", "-n", "id"},
+		},
+		{
+			name:    "deny",
+			mode:    EscalationDeny,
+			args:    []string{"sudo", "id"},
+			wantErr: true,
+		},
+		{
+			name:     "doas",
+			mode:     EscalationDoas,
+			args:     []string{"sudo", "-H", "id"},
+			wantArgs: []string{"doas", "id"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Keep sudo resolvable so auto mode doesn't substitute doas.
+			t.Setenv("PATH", "/usr/bin:/bin")
+			var got []string
+			next := func(ctx context.Context, args []string) error {
+				got = args
+				return nil
+			}
+			script := Script{Content: strings.Join(tt.args, " "), Name: "test.sh"}
+			e := newEscalator(&script, tt.mode, nil, log.New("test"))
+			e.isRoot = tt.isRoot
+
+			prog, _, err := parseScript(script, DialectAuto, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runner, err := interp.New(
+				interp.StdIO(strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}),
+				interp.ExecHandlers(func(interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+					return e.exec(next)
+				}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = runner.Run(context.Background(), prog)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.wantArgs) {
+				t.Errorf("next args = %v, want %v", got, tt.wantArgs)
+			}
+			if len(e.escalated()) != 1 {
+				t.Errorf("escalated() = %d commands, want 1", len(e.escalated()))
+			}
+		})
+	}
+}
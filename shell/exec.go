@@ -0,0 +1,164 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// killTimeout matches the grace period interp.DefaultExecHandler gives
+// processes between interrupt and kill when the context is cancelled.
+const killTimeout = 2 * time.Second
+
+// stdio holds the streams a command is started with.
+type stdio struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// handlerStdio returns the interpreter's current streams.
+func handlerStdio(hc interp.HandlerContext) stdio {
+	return stdio{stdin: hc.Stdin, stdout: hc.Stdout, stderr: hc.Stderr}
+}
+
+// stdinKey is the context key for the stdin external commands read in
+// place of the interpreter's, set when Options.Answers feeds `read`.
+type stdinKey struct{}
+
+// execCtx returns the handler context for running an external command,
+// with its stdin replaced if ctx says so. Exec handlers that read stdin
+// use it rather than interp.HandlerCtx.
+func execCtx(ctx context.Context) interp.HandlerContext {
+	hc := interp.HandlerCtx(ctx)
+	if r, ok := ctx.Value(stdinKey{}).(io.Reader); ok {
+		hc.Stdin = r
+	}
+	return hc
+}
+
+// execHook customizes the external commands started by execCommand.
+type execHook interface {
+	// prepare is called before the command is started. It may replace
+	// the command, whose Path is empty if it was not found.
+	prepare(hc interp.HandlerContext, cmd *exec.Cmd) error
+	// started is called once the command has started. If it returns an
+	// error, the command is killed.
+	started(cmd *exec.Cmd) error
+	// exited is called once the command has exited, with the arguments
+	// it was run with before prepare.
+	exited(hc interp.HandlerContext, args []string, cmd *exec.Cmd)
+}
+
+// execHooksKey is the context key for the hooks run by execCommand.
+type execHooksKey struct{}
+
+func withExecHooks(ctx context.Context, hooks []execHook) context.Context {
+	return context.WithValue(ctx, execHooksKey{}, hooks)
+}
+
+// execCommand runs an external command like interp.DefaultExecHandler,
+// but with caller-provided streams, so that middlewares can observe or
+// replace what the command reads and writes. It runs the hooks from ctx.
+func execCommand(ctx context.Context, hc interp.HandlerContext, args []string, io stdio) error {
+	cmd := &exec.Cmd{
+		Args:   args,
+		Env:    execEnv(hc),
+		Dir:    hc.Dir,
+		Stdin:  io.stdin,
+		Stdout: io.stdout,
+		Stderr: io.stderr,
+	}
+	path, lookErr := interp.LookPathDir(hc.Dir, hc.Env, args[0])
+	cmd.Path = path
+
+	hooks, _ := ctx.Value(execHooksKey{}).([]execHook)
+	for _, h := range hooks {
+		if err := h.prepare(hc, cmd); err != nil {
+			_, _ = fmt.Fprintln(io.stderr, err)
+			return interp.ExitStatus(126)
+		}
+	}
+	if cmd.Path == "" {
+		_, _ = fmt.Fprintln(io.stderr, lookErr)
+		return interp.ExitStatus(127)
+	}
+
+	err := cmd.Start()
+	if err == nil {
+		for _, h := range hooks {
+			if hookErr := h.started(cmd); hookErr != nil {
+				_, _ = fmt.Fprintln(io.stderr, hookErr)
+				_ = cmd.Process.Kill()
+				_ = cmd.Wait()
+				return interp.ExitStatus(126)
+			}
+		}
+		stop := context.AfterFunc(ctx, func() {
+			if runtime.GOOS == "windows" {
+				_ = cmd.Process.Signal(os.Kill)
+				return
+			}
+			_ = cmd.Process.Signal(stopSignal(ctx))
+			time.Sleep(killTimeout)
+			_ = cmd.Process.Signal(os.Kill)
+		})
+		defer stop()
+		err = cmd.Wait()
+		for _, h := range hooks {
+			h.exited(hc, args, cmd)
+		}
+	}
+	return exitError(ctx, io.stderr, err)
+}
+
+// execHandler replaces interp.DefaultExecHandler with execCommand.
+func execHandler(_ interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		hc := execCtx(ctx)
+		return execCommand(ctx, hc, args, handlerStdio(hc))
+	}
+}
+
+// exitError converts the error from running a process to what the
+// interpreter expects from an exec handler.
+func exitError(ctx context.Context, stderr io.Writer, err error) error {
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *exec.ExitError:
+		if status, ok := err.Sys().(interface {
+			Signaled() bool
+			Signal() syscall.Signal
+		}); ok && status.Signaled() {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return interp.ExitStatus(128 + status.Signal())
+		}
+		return interp.ExitStatus(err.ExitCode())
+	case *exec.Error:
+		// The command did not start.
+		_, _ = fmt.Fprintf(stderr, "%v\n", err)
+		return interp.ExitStatus(127)
+	default:
+		return err
+	}
+}
+
+// execEnv returns the exported variables of the interpreter's environment.
+func execEnv(hc interp.HandlerContext) []string {
+	var env []string
+	for name, vr := range hc.Env.Each {
+		if vr.Exported && vr.IsSet() {
+			env = append(env, name+"="+vr.String())
+		}
+	}
+	return env
+}
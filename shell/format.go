@@ -0,0 +1,164 @@
+package shell
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// FormatOptions configures how Format prints a script. The zero value
+// prints in the style of shfmt's defaults, indenting with tabs.
+type FormatOptions struct {
+	// Dialect selects the shell language the script is parsed as.
+	// By default it is detected from the script's shebang.
+	Dialect Dialect
+
+	// Indent is the number of spaces to indent by, or 0 for tabs.
+	Indent uint
+
+	// BinaryNextLine puts binary operators such as && and | at the start
+	// of continuation lines.
+	BinaryNextLine bool
+
+	// SwitchCaseIndent indents case patterns within case statements.
+	SwitchCaseIndent bool
+
+	// SpaceRedirects puts a space after redirect operators, as in "> file".
+	SpaceRedirects bool
+
+	// FunctionNextLine puts the opening brace of functions on its own line.
+	FunctionNextLine bool
+
+	// StripComments removes comments, other than the shebang line.
+	StripComments bool
+
+	// Simplify rewrites constructs to simpler equivalents, such as
+	// "$((${a}))" to "$((a))", like shfmt -s.
+	Simplify bool
+
+	// Minify prints the script as compactly as possible.
+	Minify bool
+}
+
+// Format parses a script and prints it in a consistent style.
+func Format(script Script, opts FormatOptions) (string, error) {
+	prog, err := parseFormatted(script, opts.Dialect, !opts.StripComments)
+	if err != nil {
+		return "", err
+	}
+	if opts.Simplify {
+		syntax.Simplify(prog)
+	}
+	printer := syntax.NewPrinter(
+		syntax.Indent(opts.Indent),
+		syntax.BinaryNextLine(opts.BinaryNextLine),
+		syntax.SwitchCaseIndent(opts.SwitchCaseIndent),
+		syntax.SpaceRedirects(opts.SpaceRedirects),
+		syntax.FunctionNextLine(opts.FunctionNextLine),
+		syntax.Minify(opts.Minify),
+	)
+	return printFormatted(script, printer, prog, opts.StripComments)
+}
+
+// Normalize returns a script's canonical form: it is simplified, stripped
+// of comments and printed with the default options, ignoring the original
+// layout. Scripts that differ only in whitespace, line breaks, statement
+// separators and comments have the same canonical form.
+func Normalize(script Script) (string, error) {
+	prog, err := parseFormatted(script, DialectAuto, false)
+	if err != nil {
+		return "", err
+	}
+	canonicalize(prog)
+	return printFormatted(script, syntax.NewPrinter(), prog, true)
+}
+
+// canonicalize simplifies prog and discards its layout. The printer
+// follows the original line breaks where positions allow, so every
+// position is moved onto one line. Whether a position is set can change
+// what is printed, as with the word list of a for loop, so unset ones
+// are kept. Heredoc bodies must still end before what follows them, such
+// as the closing parenthesis of a command substitution, so each body
+// that ends moves later positions onto the next line.
+func canonicalize(prog *syntax.File) {
+	syntax.Simplify(prog)
+	var bodyEnds []uint
+	syntax.Walk(prog, func(node syntax.Node) bool {
+		if r, ok := node.(*syntax.Redirect); ok && r.Hdoc != nil {
+			bodyEnds = append(bodyEnds, r.Hdoc.End().Offset())
+		}
+		return true
+	})
+	slices.Sort(bodyEnds)
+	canonicalPositions(reflect.ValueOf(prog), bodyEnds)
+}
+
+// Digest returns the hex SHA-256 digest of a script's canonical form, as
+// returned by Normalize, for telling whether two versions of a script
+// differ in more than layout and comments.
+func Digest(script Script) (string, error) {
+	canonical, err := Normalize(script)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func parseFormatted(script Script, dialect Dialect, comments bool) (*syntax.File, error) {
+	dialect = dialect.resolve(script.Content)
+	parser := syntax.NewParser(syntax.Variant(dialect.lang()), syntax.KeepComments(comments))
+	return parser.Parse(strings.NewReader(script.Content), script.Name)
+}
+
+// printFormatted prints prog. The shebang selects the interpreter, so it
+// is kept even when other comments are stripped.
+func printFormatted(script Script, printer *syntax.Printer, prog *syntax.File, stripped bool) (string, error) {
+	var sb strings.Builder
+	if stripped && strings.HasPrefix(script.Content, "#!") {
+		shebang, _, _ := strings.Cut(script.Content, "\n")
+		sb.WriteString(strings.TrimRight(shebang, " \t\r"))
+		sb.WriteByte('\n')
+	}
+	if err := printer.Print(&sb, prog); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+var posType = reflect.TypeFor[syntax.Pos]()
+
+// canonicalPositions moves every set syntax.Pos in the tree rooted at v
+// to the start of a line counting the heredoc bodies ending before it.
+// Offsets are kept: the printer only compares them, to order redirections
+// among words, so their values do not affect the output.
+func canonicalPositions(v reflect.Value, bodyEnds []uint) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			canonicalPositions(v.Elem(), bodyEnds)
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			canonicalPositions(v.Index(i), bodyEnds)
+		}
+	case reflect.Struct:
+		if v.Type() == posType {
+			pos := v.Interface().(syntax.Pos)
+			if pos.IsValid() {
+				line, _ := slices.BinarySearch(bodyEnds, pos.Offset()+1)
+				v.Set(reflect.ValueOf(syntax.NewPos(pos.Offset(), uint(line)+1, 1)))
+			}
+			return
+		}
+		for i := range v.NumField() {
+			if v.Field(i).CanSet() {
+				canonicalPositions(v.Field(i), bodyEnds)
+			}
+		}
+	}
+}
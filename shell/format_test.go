@@ -0,0 +1,126 @@
+package shell
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	const content = "#!/bin/bash  \n# install\nif [ -n \"$x\" ];then echo  $(( ${n} + 1 )) >out # count\nfi\ncase $a in\na) b;;\nesac\n"
+	tests := []struct {
+		name string
+		opts FormatOptions
+		want string
+	}{
+		{
+			name: "defaults",
+			want: "#!/bin/bash\n# install\nif [ -n \"$x\" ]; then\n\techo $((${n} + 1)) >out # count\nfi\ncase $a in\na) b ;;\nesac\n",
+		},
+		{
+			name: "spaces and case indent",
+			opts: FormatOptions{Indent: 2, SwitchCaseIndent: true, SpaceRedirects: true},
+			want: "#!/bin/bash\n# install\nif [ -n \"$x\" ]; then\n  echo $((${n} + 1)) > out # count\nfi\ncase $a in\n  a) b ;;\nesac\n",
+		},
+		{
+			name: "strip comments and simplify",
+			opts: FormatOptions{StripComments: true, Simplify: true},
+			want: "#!/bin/bash\nif [ -n \"$x\" ]; then\n\techo $((n + 1)) >out\nfi\ncase $a in\na) b ;;\nesac\n",
+		},
+		{
+			name: "minify",
+			opts: FormatOptions{Minify: true},
+			want: "#!/bin/bash\nif [ -n \"$x\" ];then\necho $(($n+1)) >out\nfi\ncase $a in\na)b\nesac\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format(Script{Name: "t.sh", Content: content}, tt.opts)
+			if err != nil {
+				t.Fatalf("Format() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Format() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Format(Script{Name: "t.sh", Content: "if true; then"}, FormatOptions{}); err == nil {
+		t.Error("Format() of invalid script succeeded")
+	}
+	if _, err := Format(Script{Name: "t.sh", Content: "a=(1 2)"}, FormatOptions{Dialect: DialectPOSIX}); err == nil {
+		t.Error("Format() of bash array as POSIX succeeded")
+	}
+}
+
+func TestDigest(t *testing.T) {
+	digest := func(content string) string {
+		t.Helper()
+		d, err := Digest(Script{Name: "t.sh", Content: content})
+		if err != nil {
+			t.Fatalf("Digest(%q) error: %v", content, err)
+		}
+		return d
+	}
+
+	base := digest("#!/bin/sh\necho hello\nif true; then x=$((${n}+1)); fi\n")
+	same := []string{
+		"#!/bin/sh\n# Say hello\necho   hello   # greet\n\nif true\nthen\n  x=$(( n + 1 ))\nfi",
+		"#!/bin/sh\necho hello; if true; then x=$((n+1)); fi\n",
+	}
+	for _, content := range same {
+		if d := digest(content); d != base {
+			t.Errorf("Digest(%q) differs from equivalent script", content)
+		}
+	}
+	different := []string{
+		"#!/bin/sh\necho hello world\nif true; then x=$((${n}+1)); fi\n",
+		"#!/bin/bash\necho hello\nif true; then x=$((${n}+1)); fi\n",
+		"#!/bin/sh\necho 'hello'\nif true; then x=$((${n}+1)); fi\n",
+		"#!/bin/sh\necho hello\nif true; then x=$((${n}+1)); fi\nfor f in /usr/local/bin/tool; do rm -f \"$f\"; done\n",
+	}
+	for _, content := range different {
+		if d := digest(content); d == base {
+			t.Errorf("Digest(%q) equals that of a different script", content)
+		}
+	}
+	if digest(`for f in /usr/local/bin/tool; do rm -f "$f"; done`) == digest(`for f in / /home; do rm -f "$f"; done`) {
+		t.Error("Digest() ignores for loop word lists")
+	}
+	if len(base) != 64 {
+		t.Errorf("Digest() = %q, want 64 hex digits", base)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "layout and comments",
+			content: "#!/bin/sh -e\n# helpers\nf() {\n  a  # first\n  b | c\n}; f; cat <<EOF\n# kept\nEOF\n",
+			want:    "#!/bin/sh -e\nf() {\n\ta\n\tb | c\n}\nf\ncat <<EOF\n# kept\nEOF\n",
+		},
+		{
+			name:    "word lists",
+			content: "for i in 1 2\ndo\n  echo $i\ndone\nfor j; do :; done\nselect x in a b; do break; done\n",
+			want:    "for i in 1 2; do echo $i; done\nfor j; do :; done\nselect x in a b; do break; done\n",
+		},
+		{
+			name:    "heredoc in command substitution",
+			content: "echo \"$(\n  cat <<EOF\nhi\nEOF\n)\" >out; echo after\n",
+			want:    "echo \"$(cat <<EOF\nhi\nEOF\n)\" >out\necho after\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Normalize(Script{Name: "t.sh", Content: tt.content})
+			if err != nil {
+				t.Fatalf("Normalize() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
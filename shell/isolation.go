@@ -0,0 +1,170 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Isolation runs external commands in new Linux user, mount and PID
+// namespaces and, unless ShareNetwork is set, a network namespace with
+// only a loopback interface. Commands run as root inside the namespaces,
+// mapped to the current user, so no privileges are needed where
+// unprivileged user namespaces are enabled.
+//
+// The interpreter maps the paths it opens, stats and globs itself, such
+// as in redirections and tests, to the same view. It has no hook for
+// permission checks, so `cd` and tests such as -x still check the host
+// path. In-process commands would act on the host, so Isolation cannot
+// be combined with Options.Coreutils or Options.HTTPClient.
+type Isolation struct {
+	// Prefix is a host directory bind-mounted over Target, which must
+	// exist, so that what the script writes to Target lands in Prefix.
+	Prefix string
+	Target string
+
+	// Root is a directory commands are chrooted into. It must contain
+	// /bin/sh. Target is inside Root, and /dev and /proc are mounted in
+	// Root if it has them.
+	Root string
+
+	ShareNetwork bool
+}
+
+// isolationWrapper sets up the mounts inside the new namespaces and runs
+// the command. Its arguments are the mount and chroot binaries, the
+// Isolation settings, the working directory and the command.
+const isolationWrapper = `mount=$1 chroot=$2 root=$3 prefix=$4 target=$5 dir=$6
+shift 6
+if [ -n "$prefix" ]; then
+	"$mount" --bind "$prefix" "$root$target" || exit 126
+fi
+"$mount" -t proc proc "$root/proc" 2>/dev/null
+if [ -n "$root" ]; then
+	if [ -d "$root/dev" ]; then
+		"$mount" --rbind /dev "$root/dev" 2>/dev/null
+	fi
+	exec "$chroot" "$root" /bin/sh -c 'cd "$1" || exit 126; shift; exec "$@"' sh "$dir" "$@"
+fi
+cd "$dir" || exit 126
+exec "$@"`
+
+// isolator is an execHook running commands as described by Isolation.
+type isolator struct {
+	opts          Isolation
+	sh            string
+	mount, chroot string
+}
+
+func newIsolator(opts Isolation) (*isolator, error) {
+	if err := checkIsolationSupported(); err != nil {
+		return nil, err
+	}
+	if (opts.Prefix == "") != (opts.Target == "") {
+		return nil, errors.New("isolation prefix and target must be set together")
+	}
+	for _, p := range []*string{&opts.Prefix, &opts.Root} {
+		if *p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*p)
+		if err != nil {
+			return nil, err
+		}
+		*p = abs
+	}
+	if opts.Target != "" {
+		opts.Target = filepath.Clean("/" + opts.Target)
+	}
+
+	iso := &isolator{opts: opts}
+	var err error
+	if iso.sh, err = systemLookPath("sh"); err != nil {
+		return nil, err
+	}
+	if iso.mount, err = systemLookPath("mount"); err != nil {
+		return nil, err
+	}
+	if opts.Root != "" {
+		if iso.chroot, err = systemLookPath("chroot"); err != nil {
+			return nil, err
+		}
+	}
+	return iso, nil
+}
+
+// systemLookPath finds a binary on PATH or in the system directories,
+// which are not on the PATH of unprivileged users on some systems.
+func systemLookPath(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	for _, dir := range []string{"/usr/sbin", "/sbin", "/usr/bin", "/bin"} {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && info.Mode()&0o111 != 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found", name)
+}
+
+// prepare runs the command through isolationWrapper. The command is
+// looked up inside the namespaces, where it may exist only in Prefix.
+func (iso *isolator) prepare(hc interp.HandlerContext, cmd *exec.Cmd) error {
+	wrapper := []string{"sh", "-c", isolationWrapper, "sh",
+		iso.mount, iso.chroot, iso.opts.Root, iso.opts.Prefix, iso.opts.Target, hc.Dir}
+	cmd.Path = iso.sh
+	cmd.Args = append(wrapper, cmd.Args...)
+	// The working directory may only exist inside the namespaces.
+	cmd.Dir = "/"
+	cmd.SysProcAttr = namespaceAttr(iso.opts.ShareNetwork)
+	return nil
+}
+
+func (iso *isolator) started(*exec.Cmd) error {
+	return nil
+}
+
+func (iso *isolator) exited(interp.HandlerContext, []string, *exec.Cmd) {}
+
+// hostPath maps a path as seen by isolated commands to the host.
+func (iso *isolator) hostPath(path string) string {
+	path = filepath.Clean(path)
+	if t := iso.opts.Target; t != "" {
+		if rel, ok := strings.CutPrefix(path, t); ok && (rel == "" || rel[0] == '/' || t == "/") {
+			return filepath.Join(iso.opts.Prefix, rel)
+		}
+	}
+	if iso.opts.Root != "" && path != "/dev" && !strings.HasPrefix(path, "/dev/") {
+		return filepath.Join(iso.opts.Root, path)
+	}
+	return path
+}
+
+// open maps the paths the interpreter opens, such as for redirections.
+func (iso *isolator) open(next interp.OpenHandlerFunc) interp.OpenHandlerFunc {
+	return func(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+		if path != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(interp.HandlerCtx(ctx).Dir, path)
+		}
+		return next(ctx, iso.hostPath(path), flag, perm)
+	}
+}
+
+// stat maps the paths the interpreter stats, such as for tests and cd.
+func (iso *isolator) stat(ctx context.Context, name string, followSymlinks bool) (fs.FileInfo, error) {
+	return interp.DefaultStatHandler()(ctx, iso.hostPath(name), followSymlinks)
+}
+
+// readDir maps the directories the interpreter reads for globbing.
+func (iso *isolator) readDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	return interp.DefaultReadDirHandler2()(ctx, iso.hostPath(path))
+}
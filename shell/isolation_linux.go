@@ -0,0 +1,27 @@
+//go:build linux
+
+package shell
+
+import (
+	"os"
+	"syscall"
+)
+
+func checkIsolationSupported() error {
+	return nil
+}
+
+// namespaceAttr starts a process in new namespaces, as root mapped to
+// the current user.
+func namespaceAttr(shareNetwork bool) *syscall.SysProcAttr {
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+	if !shareNetwork {
+		flags |= syscall.CLONE_NEWNET
+	}
+	return &syscall.SysProcAttr{
+		Cloneflags:                 uintptr(flags),
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+	}
+}
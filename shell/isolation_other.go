@@ -0,0 +1,16 @@
+//go:build !linux
+
+package shell
+
+import (
+	"errors"
+	"syscall"
+)
+
+func checkIsolationSupported() error {
+	return errors.New("isolation is only supported on Linux")
+}
+
+func namespaceAttr(shareNetwork bool) *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,106 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_Isolation(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("isolation is only supported on Linux")
+	}
+	if err := exec.Command("unshare", "-Urmp", "--fork", "true").Run(); err != nil {
+		t.Skipf("user namespaces unavailable: %v", err)
+	}
+
+	prefix, target := t.TempDir(), t.TempDir()
+	script := Script{Name: "install.sh", Content: `
+echo config > "$1/app.conf"
+mkdir "$1/bin"
+printf '#!/bin/sh\necho tool ran\n' > "$1/bin/tool"
+chmod +x "$1/bin/tool"
+[ -f "$1/bin/tool" ] && PATH="$1/bin:$PATH" tool
+ls "$1"/bin/*
+id -u
+sh -c 'echo pid $$'`}
+
+	var stdout, stderr bytes.Buffer
+	opts := Options{Isolation: &Isolation{Prefix: prefix, Target: target}}
+	result, err := RunWithOptions(context.Background(), script, []string{target}, strings.NewReader(""), &stdout, &stderr, opts, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v, stderr: %s", err, stderr.String())
+	}
+	if !result.Success() {
+		t.Errorf("result = %+v", result)
+	}
+
+	want := "tool ran\n" + target + "/bin/tool\n0\npid 1\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("stdout = %q, want %q, stderr: %s", got, want, stderr.String())
+	}
+	if data, err := os.ReadFile(filepath.Join(prefix, "app.conf")); err != nil || string(data) != "config\n" {
+		t.Errorf("prefix app.conf = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(prefix, "bin", "tool")); err != nil {
+		t.Errorf("prefix bin/tool: %v", err)
+	}
+	if entries, _ := os.ReadDir(target); len(entries) != 0 {
+		t.Errorf("target has %d entries, want it untouched", len(entries))
+	}
+}
+
+func TestIsolator_HostPath(t *testing.T) {
+	iso := &isolator{opts: Isolation{Prefix: "/tmp/prefix", Target: "/usr/local", Root: "/srv/root"}}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/usr/local", "/tmp/prefix"},
+		{"/usr/local/bin/tool", "/tmp/prefix/bin/tool"},
+		{"/usr/localized", "/srv/root/usr/localized"},
+		{"/etc/os-release", "/srv/root/etc/os-release"},
+		{"/dev/null", "/dev/null"},
+		{"/", "/srv/root"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := iso.hostPath(tt.path); got != tt.want {
+				t.Errorf("hostPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunWithOptions_IsolationInProcess(t *testing.T) {
+	iso := &Isolation{Prefix: t.TempDir(), Target: t.TempDir()}
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"coreutils", Options{Isolation: iso, Coreutils: true}},
+		{"http client", Options{Isolation: iso, HTTPClient: retryablehttp.NewClient()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := Script{Name: "install.sh", Content: `mkdir "$1/bin"`}
+			_, err := RunWithOptions(context.Background(), script, []string{iso.Target}, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, tt.opts, log.New("test"))
+			if err == nil || !strings.Contains(err.Error(), "cannot isolate in-process commands") {
+				t.Errorf("RunWithOptions() error = %v, want in-process isolation error", err)
+			}
+			if _, err := os.Stat(filepath.Join(iso.Target, "bin")); err == nil {
+				t.Error("script ran against the host")
+			}
+		})
+	}
+}
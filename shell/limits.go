@@ -0,0 +1,154 @@
+package shell
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Limits are resource limits applied to every external command the
+// script runs, like ulimit. Zero values leave a limit unchanged. They are
+// only supported on Linux, where each command is started by sh, which
+// sets the limits with ulimit before executing it. They therefore need
+// sh on the host, and cannot be used where there is none, such as in
+// images built FROM scratch.
+type Limits struct {
+	// CPUTime is the CPU time each process may use. A process exceeding
+	// it is sent SIGXCPU, then killed a second later.
+	CPUTime time.Duration
+
+	// Memory is the size of each process's virtual address space in
+	// bytes, rounded up to a whole kilobyte.
+	Memory uint64
+
+	// OpenFiles is the number of file descriptors each process may open.
+	OpenFiles uint64
+
+	// Processes is the number of processes the user may have, as
+	// RLIMIT_NPROC. It does not apply to root.
+	Processes uint64
+}
+
+// Limit names used in LimitViolation.
+const (
+	LimitCPUTime   = "cpu"
+	LimitMemory    = "memory"
+	LimitOpenFiles = "open-files"
+	LimitProcesses = "processes"
+)
+
+// LimitViolation records a command stopped for exceeding a limit. Only
+// CPU time violations are detected, so Limit is always LimitCPUTime.
+// Exceeding the other limits makes calls such as malloc, open or fork
+// fail within the command, which reports the failure itself and is never
+// recorded as a violation.
+type LimitViolation struct {
+	Limit   string
+	Command Command
+}
+
+// limitsWrapper sets the limits given as its first four arguments, each
+// empty if unset, and executes the command. The soft CPU limit sends
+// SIGXCPU and the hard one a second later SIGKILL. RLIMIT_NPROC is set
+// with -u in bash and -p in dash.
+const limitsWrapper = `cpu=$1 mem=$2 files=$3 procs=$4
+shift 4
+if [ -n "$cpu" ]; then
+	{ ulimit -S -t "$cpu" && ulimit -H -t $((cpu + 1)); } || exit 126
+fi
+if [ -n "$mem" ]; then
+	ulimit -v "$mem" || exit 126
+fi
+if [ -n "$files" ]; then
+	ulimit -n "$files" || exit 126
+fi
+if [ -n "$procs" ]; then
+	ulimit -u "$procs" 2>/dev/null || ulimit -p "$procs" || exit 126
+fi
+exec "$@"`
+
+// limiter is an execHook applying Limits.
+type limiter struct {
+	script *Script
+	limits Limits
+	sh     string
+
+	mu         sync.Mutex
+	violations []LimitViolation
+}
+
+func newLimiter(script *Script, limits Limits) (*limiter, error) {
+	if err := checkLimitsSupported(); err != nil {
+		return nil, err
+	}
+	sh, err := systemLookPath("sh")
+	if err != nil {
+		return nil, fmt.Errorf("resource limits are set by sh, which is required to start commands: %w", err)
+	}
+	return &limiter{script: script, limits: limits, sh: sh}, nil
+}
+
+// prepare runs the command through limitsWrapper, so that it never runs
+// without its limits. Commands that were not found are left for
+// execCommand to report.
+func (l *limiter) prepare(_ interp.HandlerContext, cmd *exec.Cmd) error {
+	if cmd.Path == "" {
+		return nil
+	}
+	var cpu, mem, files, procs string
+	if l.limits.CPUTime > 0 {
+		cpu = strconv.FormatInt(int64((l.limits.CPUTime+time.Second-1)/time.Second), 10)
+	}
+	if l.limits.Memory > 0 {
+		mem = strconv.FormatUint((l.limits.Memory+1023)/1024, 10)
+	}
+	if l.limits.OpenFiles > 0 {
+		files = strconv.FormatUint(l.limits.OpenFiles, 10)
+	}
+	if l.limits.Processes > 0 {
+		procs = strconv.FormatUint(l.limits.Processes, 10)
+	}
+	wrapper := []string{"sh", "-c", limitsWrapper, "sh", cpu, mem, files, procs}
+	cmd.Path = l.sh
+	cmd.Args = append(wrapper, cmd.Args...)
+	return nil
+}
+
+func (l *limiter) started(*exec.Cmd) error {
+	return nil
+}
+
+func (l *limiter) exited(hc interp.HandlerContext, args []string, cmd *exec.Cmd) {
+	if cmd.ProcessState == nil || l.limits.CPUTime <= 0 {
+		return
+	}
+	sig, ok := cpuLimitExceeded(cmd.ProcessState, l.limits.CPUTime)
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.violations = append(l.violations, LimitViolation{
+		Limit: LimitCPUTime,
+		Command: Command{
+			Args:     append([]string(nil), args...),
+			File:     l.script.Name,
+			Line:     hc.Pos.Line(),
+			ExitCode: 128 + int(sig),
+		},
+	})
+}
+
+// violated returns and clears the violations recorded so far.
+func (l *limiter) violated() []LimitViolation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	violations := l.violations
+	l.violations = nil
+	return violations
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package shell
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func checkLimitsSupported() error {
+	return nil
+}
+
+// cpuLimitExceeded returns the signal that killed a process if it was
+// stopped by its CPU time limit.
+func cpuLimitExceeded(state *os.ProcessState, limit time.Duration) (syscall.Signal, bool) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, false
+	}
+	switch status.Signal() {
+	case syscall.SIGXCPU:
+		return syscall.SIGXCPU, true
+	case syscall.SIGKILL:
+		return syscall.SIGKILL, state.UserTime()+state.SystemTime() >= limit
+	}
+	return 0, false
+}
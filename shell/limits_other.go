@@ -0,0 +1,18 @@
+//go:build !linux
+
+package shell
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+func checkLimitsSupported() error {
+	return errors.New("resource limits are only supported on Linux")
+}
+
+func cpuLimitExceeded(state *os.ProcessState, limit time.Duration) (syscall.Signal, bool) {
+	return 0, false
+}
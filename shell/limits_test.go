@@ -0,0 +1,61 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_Limits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+
+	var stdout bytes.Buffer
+	// Limits are set before the command executes, so they apply from its
+	// first instruction.
+	script := Script{Name: "test.sh", Content: `sh -c 'ulimit -n; ulimit -v'; no-such-command || echo $?`}
+	opts := Options{Limits: &Limits{OpenFiles: 64, Memory: 512 << 20}}
+	result, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, opts, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	if want := "64\n524288\n127\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+	if len(result.LimitViolations) != 0 {
+		t.Errorf("LimitViolations = %+v, want none", result.LimitViolations)
+	}
+}
+
+func TestRunWithOptions_CPULimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+
+	script := Script{Name: "test.sh", Content: "echo start\nsh -c 'while :; do :; done'\necho after"}
+	opts := Options{Limits: &Limits{CPUTime: time.Second}}
+	var stdout bytes.Buffer
+	result, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, opts, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	if stdout.String() != "start\nafter\n" {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+	if len(result.LimitViolations) != 1 {
+		t.Fatalf("LimitViolations = %+v, want 1", result.LimitViolations)
+	}
+	v := result.LimitViolations[0]
+	if v.Limit != LimitCPUTime || v.Command.Line != 2 || v.Command.Args[0] != "sh" {
+		t.Errorf("LimitViolations[0] = %+v", v)
+	}
+	if v.Command.ExitCode != 152 {
+		t.Errorf("ExitCode = %d, want 152 (SIGXCPU)", v.Command.ExitCode)
+	}
+}
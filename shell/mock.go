@@ -0,0 +1,136 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// MockFunc implements a fake command. It receives the full argument list,
+// including the command name, and returns the command's exit status.
+type MockFunc func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+// MockCall records one invocation of an external command.
+type MockCall struct {
+	Args  []string
+	Stdin string // what the mock read from stdin
+	Line  uint
+}
+
+// Mocks is a registry of fake commands for unit-testing scripts. Pass it
+// as Options.Mocks; external commands are then served by registered mocks
+// and every call is recorded for assertions. Shell builtins such as echo
+// and test cannot be mocked.
+type Mocks struct {
+	// Passthrough runs commands without a mock normally. By default they
+	// fail with status 127, keeping tests hermetic.
+	Passthrough bool
+
+	mu       sync.Mutex
+	commands map[string]MockFunc
+	calls    []MockCall
+}
+
+// NewMocks creates an empty mock registry.
+func NewMocks() *Mocks {
+	return &Mocks{commands: map[string]MockFunc{}}
+}
+
+// Register installs fn as the implementation of the named command.
+func (m *Mocks) Register(name string, fn MockFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands[name] = fn
+}
+
+// MockOutput returns a MockFunc that writes stdout and exits with code.
+func MockOutput(stdout string, code int) MockFunc {
+	return func(_ []string, _ io.Reader, w, _ io.Writer) int {
+		_, _ = io.WriteString(w, stdout)
+		return code
+	}
+}
+
+// Calls returns every external command invocation, mocked or not,
+// in the order they started.
+func (m *Mocks) Calls() []MockCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MockCall(nil), m.calls...)
+}
+
+// CallsTo returns the invocations of the named command.
+func (m *Mocks) CallsTo(name string) []MockCall {
+	var calls []MockCall
+	for _, c := range m.Calls() {
+		if c.Args[0] == name {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Called returns true if the named command was invoked with args
+// beginning with the given prefix.
+func (m *Mocks) Called(name string, prefix ...string) bool {
+	for _, c := range m.CallsTo(name) {
+		if len(c.Args)-1 >= len(prefix) && slices.Equal(c.Args[1:len(prefix)+1], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Mocks) lookup(name string) (MockFunc, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn, ok := m.commands[name]
+	return fn, ok
+}
+
+func (m *Mocks) record(call MockCall) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, call)
+	return len(m.calls) - 1
+}
+
+func (m *Mocks) setStdin(i int, stdin string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[i].Stdin = stdin
+}
+
+func (m *Mocks) exec(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		hc := execCtx(ctx)
+		i := m.record(MockCall{Args: append([]string(nil), args...), Line: hc.Pos.Line()})
+
+		fn, ok := m.lookup(args[0])
+		if !ok {
+			if m.Passthrough {
+				return next(ctx, args)
+			}
+			_, _ = fmt.Fprintf(hc.Stderr, "%s: command not mocked\n", args[0])
+			return interp.ExitStatus(127)
+		}
+
+		var stdin bytes.Buffer
+		var r io.Reader = strings.NewReader("")
+		if hc.Stdin != nil {
+			r = io.TeeReader(hc.Stdin, &stdin)
+		}
+		code := fn(args, r, hc.Stdout, hc.Stderr)
+		m.setStdin(i, stdin.String())
+		if code != 0 {
+			return interp.ExitStatus(code)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,99 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_Mocks(t *testing.T) {
+	mocks := NewMocks()
+	mocks.Register("uname", MockOutput("Darwin\n", 0))
+	mocks.Register("brew", func(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		_, _ = fmt.Fprintf(stdout, "brew %s\n", strings.Join(args[1:], " "))
+		return 0
+	})
+	mocks.Register("wc", func(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		data, _ := io.ReadAll(stdin)
+		_, _ = fmt.Fprintf(stdout, "%d\n", len(data))
+		return 0
+	})
+	mocks.Register("apt-get", MockOutput("", 100))
+
+	script := Script{
+		Name: "install.sh",
+		Content: `case "$(uname)" in
+Darwin) brew install tool ;;
+*) apt-get install -y tool ;;
+esac
+printf abc | wc -c`,
+	}
+
+	var stdout bytes.Buffer
+	_, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, Options{Mocks: mocks}, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+
+	if got, want := stdout.String(), "brew install tool\n3\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if !mocks.Called("brew", "install", "tool") {
+		t.Error("brew install tool should have been called")
+	}
+	if mocks.Called("apt-get") {
+		t.Error("apt-get should not have been called")
+	}
+	if calls := mocks.CallsTo("wc"); len(calls) != 1 || calls[0].Stdin != "abc" || calls[0].Line != 5 {
+		t.Errorf("CallsTo(wc) = %+v", calls)
+	}
+	if got := len(mocks.Calls()); got != 3 {
+		t.Errorf("len(Calls()) = %d, want 3", got)
+	}
+}
+
+func TestRunWithOptions_MocksExitCode(t *testing.T) {
+	mocks := NewMocks()
+	mocks.Register("false-ish", MockOutput("", 4))
+
+	script := Script{Content: "false-ish", Name: "test.sh"}
+	result, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{Mocks: mocks}, log.New("test"))
+	if err == nil {
+		t.Fatal("RunWithOptions() should fail")
+	}
+	if result.ExitCode != 4 {
+		t.Errorf("ExitCode = %d, want 4", result.ExitCode)
+	}
+}
+
+func TestRunWithOptions_MocksUnregistered(t *testing.T) {
+	tests := []struct {
+		name        string
+		passthrough bool
+		wantErr     bool
+	}{
+		{name: "hermetic", wantErr: true},
+		{name: "passthrough", passthrough: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mocks := NewMocks()
+			mocks.Passthrough = tt.passthrough
+			script := Script{Content: "sh -c 'exit 0'", Name: "test.sh"}
+
+			_, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{Mocks: mocks}, log.New("test"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RunWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !mocks.Called("sh", "-c") {
+				t.Error("sh -c should be recorded")
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package shell
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/installable-sh/lib/version"
+)
+
+// otlpScope names this package as the instrumentation scope of its spans.
+const otlpScope = "github.com/installable-sh/lib/shell"
+
+// OTLPFileExporter appends spans to a file in the OTLP/JSON format, one
+// ExportTraceServiceRequest per line, as read by the OpenTelemetry
+// Collector's otlpjsonfile receiver. It works without a collector, so
+// files can be gathered from hosts later.
+type OTLPFileExporter struct {
+	resource map[string]any
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewOTLPFileExporter opens path for appending spans. Resource attributes
+// describe the process emitting them, such as "service.name" and
+// "host.name"; service.name defaults to "installable".
+func NewOTLPFileExporter(path string, resource map[string]any) (*OTLPFileExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	resource = maps.Clone(resource)
+	if resource == nil {
+		resource = map[string]any{}
+	}
+	if _, ok := resource["service.name"]; !ok {
+		resource["service.name"] = "installable"
+	}
+	return &OTLPFileExporter{resource: resource, file: f}, nil
+}
+
+// ExportSpans writes spans as one line of OTLP/JSON.
+func (e *OTLPFileExporter) ExportSpans(_ context.Context, spans []Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	otlpSpans := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		otlpSpans[i] = newOTLPSpan(s)
+	}
+	data, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes(e.resource)},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpInstrumentationScope{Name: otlpScope, Version: version.Get()},
+			Spans: otlpSpans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.file == nil {
+		return os.ErrClosed
+	}
+	_, err = e.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (e *OTLPFileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Close()
+	e.file = nil
+	return err
+}
+
+// The otlp types mirror the JSON mapping of the OTLP trace protobufs.
+// Trace and span IDs are hex strings and 64-bit integers are decimal
+// strings, as the mapping requires.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpInstrumentationScope `json:"scope"`
+	Spans []otlpSpan               `json:"spans"`
+}
+
+type otlpInstrumentationScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+// Span kinds and status codes from the OTLP protobufs.
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+func newOTLPSpan(s Span) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentID,
+		Name:              s.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Attributes:        otlpAttributes(s.Attributes),
+		Status:            otlpStatus{Code: otlpStatusOK},
+	}
+	if s.Error != "" {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+	}
+	return span
+}
+
+// otlpAttributes converts attributes, sorted by key for stable output.
+func otlpAttributes(attrs map[string]any) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, k := range slices.Sorted(maps.Keys(attrs)) {
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: otlpValue(attrs[k])})
+	}
+	return kvs
+}
+
+func otlpValue(v any) otlpAnyValue {
+	switch v := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpAnyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpAnyValue{IntValue: &s}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	case []string:
+		values := make([]otlpAnyValue, len(v))
+		for i, s := range v {
+			values[i] = otlpValue(s)
+		}
+		return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+	default:
+		s := fmt.Sprint(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+}
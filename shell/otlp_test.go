@@ -0,0 +1,88 @@
+package shell
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOTLPFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exporter, err := NewOTLPFileExporter(path, map[string]any{"host.name": "box"})
+	if err != nil {
+		t.Fatalf("NewOTLPFileExporter() error: %v", err)
+	}
+	start := time.Unix(1700000000, 5)
+	spans := []Span{
+		{
+			TraceID:  "0af7651916cd43dd8448eb211c80319c",
+			SpanID:   "00f067aa0ba902b7",
+			ParentID: "b7ad6b7169203331",
+			Name:     "curl",
+			Start:    start,
+			End:      start.Add(time.Second),
+			Attributes: map[string]any{
+				"process.command_args": []string{"curl", "-fsSL"},
+				"process.exit.code":    22,
+				"ok":                   false,
+			},
+			Error: "exit status 22",
+		},
+		{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "b7ad6b7169203331", Name: "install.sh", Start: start, End: start},
+	}
+	for range 2 {
+		if err := exporter.ExportSpans(context.Background(), spans); err != nil {
+			t.Fatalf("ExportSpans() error: %v", err)
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if err := exporter.ExportSpans(context.Background(), spans); err == nil {
+		t.Error("ExportSpans() after Close() succeeded")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+
+	want := `{"resourceSpans":[{"resource":{"attributes":[` +
+		`{"key":"host.name","value":{"stringValue":"box"}},` +
+		`{"key":"service.name","value":{"stringValue":"installable"}}]},` +
+		`"scopeSpans":[{"scope":{"name":"github.com/installable-sh/lib/shell"},"spans":[` +
+		`{"traceId":"0af7651916cd43dd8448eb211c80319c","spanId":"00f067aa0ba902b7","parentSpanId":"b7ad6b7169203331",` +
+		`"name":"curl","kind":1,"startTimeUnixNano":"1700000000000000005","endTimeUnixNano":"1700000001000000005",` +
+		`"attributes":[{"key":"ok","value":{"boolValue":false}},` +
+		`{"key":"process.command_args","value":{"arrayValue":{"values":[{"stringValue":"curl"},{"stringValue":"-fsSL"}]}}},` +
+		`{"key":"process.exit.code","value":{"intValue":"22"}}],` +
+		`"status":{"code":2,"message":"exit status 22"}},` +
+		`{"traceId":"0af7651916cd43dd8448eb211c80319c","spanId":"b7ad6b7169203331","name":"install.sh","kind":1,` +
+		`"startTimeUnixNano":"1700000000000000005","endTimeUnixNano":"1700000000000000005","status":{"code":1}}]}]}]}`
+
+	// The scope version depends on the build, so it is compared apart.
+	var got map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	scope := got["resourceSpans"].([]any)[0].(map[string]any)["scopeSpans"].([]any)[0].(map[string]any)["scope"].(map[string]any)
+	if scope["version"] == "" {
+		t.Error("scope version is empty")
+	}
+	delete(scope, "version")
+	normalized, _ := json.Marshal(got)
+	var wantJSON map[string]any
+	_ = json.Unmarshal([]byte(want), &wantJSON)
+	wantNormalized, _ := json.Marshal(wantJSON)
+	if string(normalized) != string(wantNormalized) {
+		t.Errorf("line =\n%s\nwant\n%s", normalized, wantNormalized)
+	}
+}
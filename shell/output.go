@@ -0,0 +1,122 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// orDiscard returns w, or io.Discard if w is nil, as the interpreter
+// does for its output streams.
+func orDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
+
+// ringBuffer keeps the last size bytes written to it.
+type ringBuffer struct {
+	mu   sync.Mutex
+	size int
+	buf  []byte
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(p) >= r.size {
+		r.buf = append(r.buf[:0], p[len(p)-r.size:]...)
+		return len(p), nil
+	}
+	if over := len(r.buf) + len(p) - r.size; over > 0 {
+		r.buf = append(r.buf[:0], r.buf[over:]...)
+	}
+	r.buf = append(r.buf, p...)
+	return len(p), nil
+}
+
+func (r *ringBuffer) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return string(r.buf)
+}
+
+func (r *ringBuffer) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf = r.buf[:0]
+}
+
+// outputCapture keeps the tail of a script's stdout and stderr.
+type outputCapture struct {
+	stdout, stderr, combined *ringBuffer
+}
+
+func newOutputCapture(size int) *outputCapture {
+	return &outputCapture{
+		stdout:   &ringBuffer{size: size},
+		stderr:   &ringBuffer{size: size},
+		combined: &ringBuffer{size: size},
+	}
+}
+
+// tee returns writers that copy to stdout and stderr and to the capture.
+func (c *outputCapture) tee(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	return io.MultiWriter(stdout, c.stdout, c.combined), io.MultiWriter(stderr, c.stderr, c.combined)
+}
+
+func (c *outputCapture) reset() {
+	c.stdout.reset()
+	c.stderr.reset()
+	c.combined.reset()
+}
+
+func (c *outputCapture) fill(result *Result) {
+	result.Stdout = c.stdout.String()
+	result.Stderr = c.stderr.String()
+	result.Output = c.combined.String()
+}
+
+// prefixWriter writes a prefix at the start of every line. Writers that
+// share a mutex write whole lines without interleaving, as long as each
+// line is written at once.
+type prefixWriter struct {
+	mu      *sync.Mutex
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+	lineBuf bytes.Buffer
+}
+
+// prefixWriters wraps stdout and stderr to prefix their lines.
+func prefixWriters(prefix string, stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	mu := &sync.Mutex{}
+	return &prefixWriter{mu: mu, w: stdout, prefix: []byte(prefix)},
+		&prefixWriter{mu: mu, w: stderr, prefix: []byte(prefix)}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	buf := &p.lineBuf
+	buf.Reset()
+	for rest := b; len(rest) > 0; {
+		if !p.midLine {
+			buf.Write(p.prefix)
+			p.midLine = true
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			buf.Write(rest)
+			break
+		}
+		buf.Write(rest[:i+1])
+		rest = rest[i+1:]
+		p.midLine = false
+	}
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,124 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"under size", []string{"ab", "cd"}, "abcd"},
+		{"wraps", []string{"abcd", "ef"}, "cdef"},
+		{"large write", []string{"ab", "cdefgh"}, "efgh"},
+		{"exact size", []string{"abcd"}, "abcd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ringBuffer{size: 4}
+			for _, w := range tt.writes {
+				if n, err := r.Write([]byte(w)); err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := r.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	stdout, stderr := prefixWriters("[x] ", &out, &out)
+	for _, w := range []struct {
+		w io.Writer
+		s string
+	}{
+		{stdout, "one\ntw"},
+		{stdout, "o\n"},
+		{stderr, "err\n\n"},
+		{stdout, "prompt? "},
+	} {
+		if _, err := w.w.Write([]byte(w.s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "[x] one\n[x] two\n[x] err\n[x] \n[x] prompt? "
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestPrefixWriter_Concurrent(t *testing.T) {
+	var out bytes.Buffer
+	stdout, stderr := prefixWriters("> ", &out, &out)
+	var wg sync.WaitGroup
+	for _, w := range []io.Writer{stdout, stderr} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				_, _ = w.Write([]byte("line\n"))
+			}
+		}()
+	}
+	wg.Wait()
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if line != "> line" {
+			t.Fatalf("interleaved line %q", line)
+		}
+	}
+}
+
+func TestRunWithOptions_CaptureOutput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	script := Script{Name: "install.sh", Content: "echo starting\necho oops >&2\nfor i in 1 2 3; do echo step $i; done\nexit 1"}
+	opts := Options{CaptureOutput: 24, LinePrefix: "[install.sh] "}
+	result, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &stdout, &stderr, opts, log.New("test"))
+	if err == nil {
+		t.Fatal("RunWithOptions() succeeded, want exit status 1")
+	}
+
+	if want := "[install.sh] starting\n[install.sh] step 1\n[install.sh] step 2\n[install.sh] step 3\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+	if want := "[install.sh] oops\n"; stderr.String() != want {
+		t.Errorf("stderr = %q, want %q", stderr.String(), want)
+	}
+	if want := "ng\nstep 1\nstep 2\nstep 3\n"; result.Stdout != want {
+		t.Errorf("Result.Stdout = %q, want %q", result.Stdout, want)
+	}
+	if want := "oops\n"; result.Stderr != want {
+		t.Errorf("Result.Stderr = %q, want %q", result.Stderr, want)
+	}
+	if want := "ps\nstep 1\nstep 2\nstep 3\n"; result.Output != want {
+		t.Errorf("Result.Output = %q, want %q", result.Output, want)
+	}
+}
+
+func TestRunWithOptions_CaptureOutputPTY(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pseudo-terminals are only supported on Linux")
+	}
+	script := Script{Name: "test.sh", Content: "echo on tty"}
+	opts := Options{PTY: true, CaptureOutput: 1024}
+	result, err := RunWithOptions(context.Background(), script, nil, nil, nil, nil, opts, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	if want := "on tty\r\n"; result.Stdout != want {
+		t.Errorf("Result.Stdout = %q, want %q", result.Stdout, want)
+	}
+}
@@ -0,0 +1,348 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Param is a parameter declared in a script's header comment block with
+// a line such as:
+//
+//	# @param version string default=latest env=TOOL_VERSION Version to install
+//
+// After the name and type come any of `required`, `default=VALUE` and
+// `env=VAR`, which may be quoted, then the description. Types are string,
+// int, bool, path, or a list of choices such as `stable|beta`.
+type Param struct {
+	Name        string
+	Type        string
+	Default     string
+	Required    bool
+	Env         string
+	Description string
+}
+
+// Var returns the shell variable the parameter is assigned to.
+func (p Param) Var() string {
+	return strings.ReplaceAll(p.Name, "-", "_")
+}
+
+// Schema is the parameters declared by a script and the description in
+// its header comment block.
+type Schema struct {
+	Description string
+	Params      []Param
+}
+
+var paramNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// ParseSchema reads the parameter schema from the header comment block
+// of a script: the comment lines at its start, after any shebang. It
+// returns nil if the script declares no parameters.
+func ParseSchema(content string) (*Schema, error) {
+	schema := &Schema{}
+	var desc []string
+	for i, line := range strings.Split(content, "\n") {
+		if i == 0 && strings.HasPrefix(line, "#!") {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		text := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		rest, ok := strings.CutPrefix(text, "@param")
+		if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+			desc = append(desc, text)
+			continue
+		}
+		p, err := parseParam(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if slices.ContainsFunc(schema.Params, func(q Param) bool { return q.Name == p.Name }) {
+			return nil, fmt.Errorf("line %d: duplicate parameter %s", i+1, p.Name)
+		}
+		schema.Params = append(schema.Params, p)
+	}
+	if len(schema.Params) == 0 {
+		return nil, nil
+	}
+	schema.Description = strings.TrimSpace(strings.Join(desc, "\n"))
+	return schema, nil
+}
+
+func parseParam(decl string) (Param, error) {
+	fields, err := splitFields(decl)
+	if err != nil {
+		return Param{}, err
+	}
+	if len(fields) < 2 {
+		return Param{}, errors.New("@param needs a name and a type")
+	}
+	p := Param{Name: fields[0], Type: fields[1]}
+	if !paramNameRegex.MatchString(p.Name) || p.Name == "help" {
+		return Param{}, fmt.Errorf("invalid parameter name %q", p.Name)
+	}
+	switch p.Type {
+	case "string", "int", "bool", "path":
+	default:
+		if !strings.Contains(p.Type, "|") {
+			return Param{}, fmt.Errorf("parameter %s has unknown type %q", p.Name, p.Type)
+		}
+	}
+
+	rest := fields[2:]
+	for len(rest) > 0 {
+		key, value, _ := strings.Cut(rest[0], "=")
+		switch {
+		case rest[0] == "required":
+			p.Required = true
+		case key == "default":
+			p.Default = value
+		case key == "env":
+			p.Env = value
+		default:
+			p.Description = strings.Join(rest, " ")
+			rest = nil
+			continue
+		}
+		rest = rest[1:]
+	}
+	if p.Default != "" {
+		if _, err := p.convert(p.Default); err != nil {
+			return Param{}, fmt.Errorf("parameter %s has invalid default: %w", p.Name, err)
+		}
+	}
+	return p, nil
+}
+
+// splitFields splits s on whitespace, keeping single- or double-quoted
+// sections together and removing the quotes.
+func splitFields(s string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	inField := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inField = r, true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+// convert validates a value and returns it in canonical form: bools are
+// true or false, so that scripts can run them as commands, and paths
+// are absolute.
+func (p Param) convert(value string) (string, error) {
+	switch p.Type {
+	case "string":
+		return value, nil
+	case "int":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an integer", value)
+		}
+		return strconv.Itoa(n), nil
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a boolean", value)
+		}
+		return strconv.FormatBool(b), nil
+	case "path":
+		if value == "" {
+			return "", nil
+		}
+		if rest, ok := strings.CutPrefix(value, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				value = filepath.Join(home, rest)
+			}
+		}
+		return filepath.Abs(value)
+	default:
+		choices := strings.Split(p.Type, "|")
+		if !slices.Contains(choices, value) {
+			return "", fmt.Errorf("%q is not one of %s", value, strings.Join(choices, ", "))
+		}
+		return value, nil
+	}
+}
+
+// WantsHelp returns true if args ask for help with -h or --help before
+// any `--`.
+func WantsHelp(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "--":
+			return false
+		case "-h", "--help":
+			return true
+		}
+	}
+	return false
+}
+
+// Parse validates args and the environment against the schema. Options
+// are given as --name=value or --name value, and bools as --name or
+// --no-name. Missing parameters take their env variable's value, looked
+// up with getenv, then their default. It returns the converted value of
+// every parameter by variable name, and the remaining positional args.
+func (s *Schema) Parse(args []string, getenv func(string) string) (map[string]string, []string, error) {
+	given := map[string]string{}
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		name, ok := strings.CutPrefix(arg, "--")
+		if !ok || name == "" {
+			positional = append(positional, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(name, "=")
+		p, found := s.param(name)
+		if !found {
+			if negated, ok := strings.CutPrefix(name, "no-"); ok && !hasValue {
+				if p, found = s.param(negated); found && p.Type == "bool" {
+					given[p.Name] = "false"
+					continue
+				}
+			}
+			return nil, nil, fmt.Errorf("unknown option --%s", name)
+		}
+		switch {
+		case hasValue:
+		case p.Type == "bool":
+			value = "true"
+		case i+1 < len(args):
+			i++
+			value = args[i]
+		default:
+			return nil, nil, fmt.Errorf("option --%s needs a value", name)
+		}
+		given[p.Name] = value
+	}
+
+	values := map[string]string{}
+	for _, p := range s.Params {
+		value, ok := given[p.Name]
+		if !ok && p.Env != "" {
+			value = getenv(p.Env)
+			ok = value != ""
+		}
+		if !ok {
+			if p.Required {
+				return nil, nil, fmt.Errorf("missing required option --%s", p.Name)
+			}
+			value = p.Default
+			if value == "" && p.Type == "bool" {
+				value = "false"
+			}
+		}
+		converted, err := p.convert(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid value for --%s: %w", p.Name, err)
+		}
+		values[p.Var()] = converted
+	}
+	return values, positional, nil
+}
+
+func (s *Schema) param(name string) (Param, bool) {
+	for _, p := range s.Params {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Param{}, false
+}
+
+// Help renders usage text for the script.
+func (s *Schema) Help(name string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Usage: %s [options] [args...]\n", name)
+	if s.Description != "" {
+		fmt.Fprintf(&sb, "\n%s\n", s.Description)
+	}
+	sb.WriteString("\nOptions:\n")
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	for _, p := range s.Params {
+		flag := "--" + p.Name
+		switch {
+		case p.Type == "bool":
+		case strings.Contains(p.Type, "|"):
+			flag += " " + p.Type
+		default:
+			flag += " <" + p.Type + ">"
+		}
+		var notes []string
+		if p.Required {
+			notes = append(notes, "required")
+		}
+		if p.Default != "" {
+			notes = append(notes, "default: "+p.Default)
+		}
+		if p.Env != "" {
+			notes = append(notes, "env: "+p.Env)
+		}
+		desc := p.Description
+		if len(notes) > 0 {
+			desc = strings.TrimSpace(desc + " (" + strings.Join(notes, ", ") + ")")
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", flag, desc)
+	}
+	fmt.Fprintf(tw, "  -h, --help\tShow this help\n")
+	_ = tw.Flush()
+	return sb.String()
+}
+
+// assignStmts returns statements assigning vars, to run before a script.
+func assignStmts(vars map[string]string, dialect Dialect) ([]*syntax.Stmt, error) {
+	var src strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		value, err := syntax.Quote(vars[name], dialect.lang())
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		fmt.Fprintf(&src, "%s=%s\n", name, value)
+	}
+	prog, err := syntax.NewParser(syntax.Variant(dialect.lang())).Parse(strings.NewReader(src.String()), "")
+	if err != nil {
+		return nil, err
+	}
+	return prog.Stmts, nil
+}
@@ -0,0 +1,200 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+const paramsScript = `#!/bin/sh
+# Installs the tool.
+# @param version string default=latest env=TOOL_VERSION Version to install
+# @param prefix path required env=PREFIX Install location
+# @param channel stable|beta default=stable
+# @param jobs int default=2 Parallel jobs
+# @param force bool Overwrite an existing install
+# @param greeting string default="hello world"
+echo "$version $prefix $channel $jobs $greeting $# $*"
+if $force; then echo forced; fi
+`
+
+func TestParseSchema(t *testing.T) {
+	schema, err := ParseSchema(paramsScript)
+	if err != nil {
+		t.Fatalf("ParseSchema() error: %v", err)
+	}
+	if schema.Description != "Installs the tool." {
+		t.Errorf("Description = %q", schema.Description)
+	}
+	want := []Param{
+		{Name: "version", Type: "string", Default: "latest", Env: "TOOL_VERSION", Description: "Version to install"},
+		{Name: "prefix", Type: "path", Required: true, Env: "PREFIX", Description: "Install location"},
+		{Name: "channel", Type: "stable|beta", Default: "stable"},
+		{Name: "jobs", Type: "int", Default: "2", Description: "Parallel jobs"},
+		{Name: "force", Type: "bool", Description: "Overwrite an existing install"},
+		{Name: "greeting", Type: "string", Default: "hello world"},
+	}
+	if !slices.Equal(schema.Params, want) {
+		t.Errorf("Params = %+v, want %+v", schema.Params, want)
+	}
+
+	if schema, err := ParseSchema("#!/bin/sh\n# Just a comment\necho hi"); err != nil || schema != nil {
+		t.Errorf("ParseSchema() without params = %+v, %v, want nil", schema, err)
+	}
+
+	for _, bad := range []string{
+		"# @param x",
+		"# @param x float",
+		"# @param 1x string",
+		"# @param x int default=abc",
+		"# @param x string default='open",
+		"# @param x string\n# @param x int",
+	} {
+		if _, err := ParseSchema(bad); err == nil {
+			t.Errorf("ParseSchema(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSchema_Parse(t *testing.T) {
+	schema, err := ParseSchema(paramsScript)
+	if err != nil {
+		t.Fatalf("ParseSchema() error: %v", err)
+	}
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		want     map[string]string
+		wantRest []string
+		wantErr  string
+	}{
+		{
+			name: "defaults",
+			args: []string{"--prefix=/opt/tool"},
+			want: map[string]string{"version": "latest", "prefix": "/opt/tool", "channel": "stable", "jobs": "2", "force": "false", "greeting": "hello world"},
+		},
+		{
+			name:     "flags and positionals",
+			args:     []string{"a", "--prefix", "/opt/x/../tool", "--force", "--jobs=08", "--channel", "beta", "b", "--", "--version"},
+			want:     map[string]string{"version": "latest", "prefix": "/opt/tool", "channel": "beta", "jobs": "8", "force": "true", "greeting": "hello world"},
+			wantRest: []string{"a", "b", "--version"},
+		},
+		{
+			name: "env",
+			args: []string{"--no-force"},
+			env:  map[string]string{"PREFIX": "/srv", "TOOL_VERSION": "1.2"},
+			want: map[string]string{"version": "1.2", "prefix": "/srv", "channel": "stable", "jobs": "2", "force": "false", "greeting": "hello world"},
+		},
+		{
+			name: "args override env",
+			args: []string{"--version=2.0"},
+			env:  map[string]string{"PREFIX": "/srv", "TOOL_VERSION": "1.2"},
+			want: map[string]string{"version": "2.0", "prefix": "/srv", "channel": "stable", "jobs": "2", "force": "false", "greeting": "hello world"},
+		},
+		{name: "missing required", wantErr: "missing required option --prefix"},
+		{name: "unknown option", args: []string{"--prefix=/", "--colour"}, wantErr: "unknown option --colour"},
+		{name: "missing value", args: []string{"--prefix"}, wantErr: "option --prefix needs a value"},
+		{name: "bad int", args: []string{"--prefix=/", "--jobs=many"}, wantErr: `invalid value for --jobs: "many" is not an integer`},
+		{name: "bad choice", args: []string{"--prefix=/", "--channel=nightly"}, wantErr: "is not one of stable, beta"},
+		{name: "bad bool", args: []string{"--prefix=/", "--force=maybe"}, wantErr: "is not a boolean"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(name string) string { return tt.env[name] }
+			got, rest, err := schema.Parse(tt.args, getenv)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error: %v", err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(rest, tt.wantRest) {
+				t.Errorf("Parse() rest = %q, want %q", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestSchema_Help(t *testing.T) {
+	schema, err := ParseSchema(paramsScript)
+	if err != nil {
+		t.Fatalf("ParseSchema() error: %v", err)
+	}
+	want := `Usage: install.sh [options] [args...]
+
+Installs the tool.
+
+Options:
+  --version <string>     Version to install (default: latest, env: TOOL_VERSION)
+  --prefix <path>        Install location (required, env: PREFIX)
+  --channel stable|beta  (default: stable)
+  --jobs <int>           Parallel jobs (default: 2)
+  --force                Overwrite an existing install
+  --greeting <string>    (default: hello world)
+  -h, --help             Show this help
+`
+	if got := schema.Help("install.sh"); got != want {
+		t.Errorf("Help() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRunWithOptions_Params(t *testing.T) {
+	script := Script{Name: "install.sh", Content: paramsScript}
+	prefix := filepath.Join(t.TempDir(), "it's here")
+	tests := []struct {
+		name         string
+		args         []string
+		wantStdout   string
+		wantStderr   string
+		wantExitCode int
+	}{
+		{
+			name:       "valid",
+			args:       []string{"--prefix", prefix, "--force", "x", "y"},
+			wantStdout: "latest " + prefix + " stable 2 hello world 2 x y\nforced\n",
+		},
+		{
+			name:       "help",
+			args:       []string{"--help"},
+			wantStdout: "Usage: install.sh [options] [args...]\n",
+		},
+		{
+			name:         "invalid",
+			args:         []string{"--jobs=x"},
+			wantStderr:   "install.sh: missing required option --prefix\nTry 'install.sh --help' for more information.\n",
+			wantExitCode: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := log.New("test")
+			logger.SetOutput(&bytes.Buffer{})
+			var stdout, stderr bytes.Buffer
+			result, _ := RunWithOptions(context.Background(), script, tt.args, strings.NewReader(""), &stdout, &stderr, Options{Params: true}, logger)
+			if result.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantExitCode)
+			}
+			if !strings.HasPrefix(stdout.String(), tt.wantStdout) {
+				t.Errorf("stdout = %q, want prefix %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+//go:build linux
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY allocates a pseudo-terminal of the given size, returning its
+// master side and the terminal device. Echo is turned off, so input
+// written to the master does not appear in its output.
+func openPTY(width, height int) (master, tty *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = master.Close()
+		}
+	}()
+
+	// Use the raw descriptor without os.File.Fd, which would make reads
+	// of the master blocking and uninterruptible by Close.
+	raw, err := master.SyscallConn()
+	if err != nil {
+		return nil, nil, err
+	}
+	var n int
+	var ioctlErr error
+	err = raw.Control(func(fd uintptr) {
+		if ioctlErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ioctlErr != nil {
+			return
+		}
+		n, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+	})
+	if err == nil {
+		err = ioctlErr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unlock pseudo-terminal: %w", err)
+	}
+
+	tty, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	ws := &unix.Winsize{Col: uint16(width), Row: uint16(height)}
+	if err := unix.IoctlSetWinsize(int(tty.Fd()), unix.TIOCSWINSZ, ws); err != nil {
+		_ = tty.Close()
+		return nil, nil, fmt.Errorf("set terminal size: %w", err)
+	}
+	termios, err := unix.IoctlGetTermios(int(tty.Fd()), unix.TCGETS)
+	if err == nil {
+		termios.Lflag &^= unix.ECHO
+		err = unix.IoctlSetTermios(int(tty.Fd()), unix.TCSETS, termios)
+	}
+	if err != nil {
+		_ = tty.Close()
+		return nil, nil, fmt.Errorf("disable terminal echo: %w", err)
+	}
+	return master, tty, nil
+}
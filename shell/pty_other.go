@@ -0,0 +1,12 @@
+//go:build !linux
+
+package shell
+
+import (
+	"errors"
+	"os"
+)
+
+func openPTY(width, height int) (master, tty *os.File, err error) {
+	return nil, nil, errors.New("pseudo-terminals are only supported on Linux")
+}
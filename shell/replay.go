@@ -0,0 +1,143 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Recording is a fixture of external command executions. It is filled in
+// by Options.Record and served back by Options.Replay, so that tests of
+// install scripts can run without executing external commands.
+type Recording struct {
+	Commands []RecordedCommand `json:"commands"`
+
+	mu     sync.Mutex
+	served []bool
+}
+
+// RecordedCommand is one external command execution.
+type RecordedCommand struct {
+	Args     []string `json:"args"`
+	Stdin    string   `json:"stdin,omitempty"`
+	Stdout   string   `json:"stdout,omitempty"`
+	Stderr   string   `json:"stderr,omitempty"`
+	ExitCode int      `json:"exit_code"`
+}
+
+// LoadRecording reads a recording from a JSON fixture file.
+func LoadRecording(path string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Recording
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid recording %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// Save writes the recording to a JSON fixture file.
+func (r *Recording) Save(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Unserved returns the recorded commands that were not replayed.
+func (r *Recording) Unserved() []RecordedCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var unserved []RecordedCommand
+	for i, c := range r.Commands {
+		if i >= len(r.served) || !r.served[i] {
+			unserved = append(unserved, c)
+		}
+	}
+	return unserved
+}
+
+func (r *Recording) add(c RecordedCommand) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Commands = append(r.Commands, c)
+}
+
+// next returns the first unserved command with the given args. Matching
+// by args rather than strictly in order keeps replay deterministic for
+// pipelines, whose commands start concurrently.
+func (r *Recording) next(args []string) (RecordedCommand, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.served) < len(r.Commands) {
+		r.served = append(r.served, make([]bool, len(r.Commands)-len(r.served))...)
+	}
+	for i, c := range r.Commands {
+		if !r.served[i] && slices.Equal(c.Args, args) {
+			r.served[i] = true
+			return c, true
+		}
+	}
+	return RecordedCommand{}, false
+}
+
+// record runs external commands itself so that their streams can be
+// captured, and appends each execution to the recording. It is the
+// innermost exec handler, so commands served in-process by other options
+// are not recorded.
+func (r *Recording) record(_ interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		hc := execCtx(ctx)
+		var stdin, stdout, stderr bytes.Buffer
+		streams := handlerStdio(hc)
+		// Terminal input never reaches EOF, so it is passed through
+		// rather than captured.
+		if streams.stdin != nil && !isTerminal(streams.stdin) {
+			streams.stdin = io.TeeReader(streams.stdin, &stdin)
+		}
+		streams.stdout = io.MultiWriter(streams.stdout, &stdout)
+		streams.stderr = io.MultiWriter(streams.stderr, &stderr)
+
+		err := execCommand(ctx, hc, args, streams)
+		r.add(RecordedCommand{
+			Args:     append([]string(nil), args...),
+			Stdin:    stdin.String(),
+			Stdout:   stdout.String(),
+			Stderr:   stderr.String(),
+			ExitCode: exitCode(err),
+		})
+		return err
+	}
+}
+
+// replay serves external commands from the recording without executing
+// them. Like record, it is the innermost exec handler, so commands served
+// in-process run as they did when recording. A command missing from the
+// recording halts the script.
+func (r *Recording) replay(_ interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		hc := execCtx(ctx)
+		c, ok := r.next(args)
+		if !ok {
+			return fmt.Errorf("replay: unexpected command: %s", quoteArgs(args))
+		}
+		_, _ = io.WriteString(hc.Stdout, c.Stdout)
+		_, _ = io.WriteString(hc.Stderr, c.Stderr)
+		if c.ExitCode != 0 {
+			return interp.ExitStatus(c.ExitCode)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_RecordReplay(t *testing.T) {
+	script := Script{
+		Content: `printf 'b\na\n' | sort
+sh -c 'echo out; echo err >&2; exit 3' || echo "status $?"`,
+		Name: "test.sh",
+	}
+	logger := log.New("test")
+
+	// Record real executions.
+	recording := &Recording{}
+	var recStdout, recStderr bytes.Buffer
+	_, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &recStdout, &recStderr, Options{Record: recording}, logger)
+	if err != nil {
+		t.Fatalf("RunWithOptions() record error: %v", err)
+	}
+
+	if len(recording.Commands) != 2 {
+		t.Fatalf("recorded %d commands, want 2: %+v", len(recording.Commands), recording.Commands)
+	}
+	sortCmd := recording.Commands[0]
+	if sortCmd.Args[0] != "sort" || sortCmd.Stdin != "b\na\n" || sortCmd.Stdout != "a\nb\n" {
+		t.Errorf("recorded sort = %+v", sortCmd)
+	}
+	shCmd := recording.Commands[1]
+	if shCmd.ExitCode != 3 || shCmd.Stdout != "out\n" || shCmd.Stderr != "err\n" {
+		t.Errorf("recorded sh = %+v", shCmd)
+	}
+
+	// Round-trip through a fixture file.
+	fixture := filepath.Join(t.TempDir(), "fixture.json")
+	if err := recording.Save(fixture); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	loaded, err := LoadRecording(fixture)
+	if err != nil {
+		t.Fatalf("LoadRecording() error: %v", err)
+	}
+
+	// Replay with nothing on PATH: output must match the recording.
+	t.Setenv("PATH", t.TempDir())
+	var stdout, stderr bytes.Buffer
+	_, err = RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &stdout, &stderr, Options{Replay: loaded}, logger)
+	if err != nil {
+		t.Fatalf("RunWithOptions() replay error: %v", err)
+	}
+	if stdout.String() != recStdout.String() {
+		t.Errorf("replay stdout = %q, want %q", stdout.String(), recStdout.String())
+	}
+	if stderr.String() != recStderr.String() {
+		t.Errorf("replay stderr = %q, want %q", stderr.String(), recStderr.String())
+	}
+	if unserved := loaded.Unserved(); len(unserved) != 0 {
+		t.Errorf("Unserved() = %+v, want none", unserved)
+	}
+}
+
+func TestRunWithOptions_RecordReplayCoreutils(t *testing.T) {
+	// An empty PATH forces the in-process mkdir to be used.
+	t.Setenv("PATH", t.TempDir())
+	script := Script{Content: `mkdir "$1/d" && /bin/sh -c 'echo made'`, Name: "test.sh"}
+	logger := log.New("test")
+
+	recording := &Recording{}
+	opts := Options{Coreutils: true, Record: recording}
+	_, err := RunWithOptions(context.Background(), script, []string{t.TempDir()}, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, opts, logger)
+	if err != nil {
+		t.Fatalf("RunWithOptions() record error: %v", err)
+	}
+	if len(recording.Commands) != 1 || recording.Commands[0].Args[0] != "/bin/sh" {
+		t.Fatalf("recorded %+v, want only /bin/sh", recording.Commands)
+	}
+
+	var stdout bytes.Buffer
+	opts = Options{Coreutils: true, Replay: recording}
+	_, err = RunWithOptions(context.Background(), script, []string{t.TempDir()}, strings.NewReader(""), &stdout, &bytes.Buffer{}, opts, logger)
+	if err != nil {
+		t.Fatalf("RunWithOptions() replay error: %v", err)
+	}
+	if stdout.String() != "made\n" {
+		t.Errorf("replay stdout = %q, want %q", stdout.String(), "made\n")
+	}
+}
+
+func TestRunWithOptions_ReplayUnexpected(t *testing.T) {
+	recording := &Recording{Commands: []RecordedCommand{{Args: []string{"uname", "-s"}, Stdout: "Linux\n"}}}
+	script := Script{Content: "uname -m", Name: "test.sh"}
+
+	_, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{Replay: recording}, log.New("test"))
+	if err == nil || !strings.Contains(err.Error(), "unexpected command") {
+		t.Errorf("RunWithOptions() error = %v, want unexpected command", err)
+	}
+	if len(recording.Unserved()) != 1 {
+		t.Errorf("Unserved() = %d, want 1", len(recording.Unserved()))
+	}
+}
+
+func TestRunWithOptions_RecordAndReplay(t *testing.T) {
+	logger := log.New("test")
+	logger.SetOutput(&bytes.Buffer{})
+	opts := Options{Record: &Recording{}, Replay: &Recording{}}
+	_, err := RunWithOptions(context.Background(), Script{Content: "true", Name: "test.sh"}, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, opts, logger)
+	if err == nil {
+		t.Error("RunWithOptions() should reject Record with Replay")
+	}
+}
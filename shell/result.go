@@ -0,0 +1,160 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Result describes the outcome of running a script.
+type Result struct {
+	// ExitCode is the script's exit status. Parse errors map to 2, as in
+	// sh, and errors that carry no exit status map to 1.
+	ExitCode int
+
+	// ParseError is true if the script failed to parse and never ran.
+	ParseError bool
+
+	// Signal is the signal that terminated the failing command, derived
+	// from the shell convention of exit statuses above 128. With
+	// Options.Signals, it is the signal that stopped the script.
+	Signal syscall.Signal
+
+	// Canceled is true if the run was stopped by context cancellation.
+	Canceled bool
+
+	// FailedCommand is the last command executed before the script
+	// exited with a non-zero status. It is nil on success.
+	FailedCommand *Command
+
+	// Escalations lists the commands the script ran through sudo or
+	// doas, with the escalation itself stripped.
+	Escalations []Command
+
+	// Env holds the environment changes made by the script when
+	// Options.CaptureEnv is set.
+	Env *EnvChanges
+
+	// LimitViolations lists the commands stopped for exceeding the CPU
+	// time in Options.Limits. Exceeding the other limits is not detected.
+	LimitViolations []LimitViolation
+
+	// Changes lists the files the script changed, sorted by path, when
+	// Options.TrackChanges is set.
+	Changes []FileChange
+
+	// Skipped lists the sections skipped because an earlier run
+	// completed them, with Options.Checkpoints.
+	Skipped []string
+
+	// Stdout, Stderr and Output hold the last Options.CaptureOutput bytes
+	// the script wrote to stdout, to stderr and to both, interleaved.
+	Stdout string
+	Stderr string
+	Output string
+
+	// Duration is the wall-clock time taken to parse and run the script.
+	Duration time.Duration
+}
+
+// Command records a simple command executed by a script.
+type Command struct {
+	Args     []string
+	File     string
+	Line     uint
+	ExitCode int
+}
+
+// String returns the command in file:line form.
+func (c *Command) String() string {
+	return fmt.Sprintf("%s:%d: %s", c.File, c.Line, quoteArgs(c.Args))
+}
+
+// Success returns true if the script exited with status 0.
+func (r *Result) Success() bool {
+	return r.ExitCode == 0
+}
+
+// commandRecorder remembers the last command executed by a script.
+// Commands in a pipeline run concurrently, so access is serialized.
+type commandRecorder struct {
+	script *Script
+	last   *Command
+	mu     sync.Mutex
+}
+
+func (c *commandRecorder) call(ctx context.Context, args []string) ([]string, error) {
+	hc := interp.HandlerCtx(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = &Command{
+		Args: append([]string(nil), args...),
+		File: c.script.Name,
+		Line: hc.Pos.Line(),
+	}
+	return args, nil
+}
+
+func (c *commandRecorder) exec(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		err := next(ctx, args)
+		hc := interp.HandlerCtx(ctx)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.last = &Command{
+			Args:     append([]string(nil), args...),
+			File:     c.script.Name,
+			Line:     hc.Pos.Line(),
+			ExitCode: exitCode(err),
+		}
+		return err
+	}
+}
+
+// lastCommand returns the last command recorded and forgets it, so that
+// the next run starts afresh.
+func (c *commandRecorder) lastCommand() *Command {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := c.last
+	c.last = nil
+	return last
+}
+
+// newResult builds a Result from the error returned by the interpreter.
+func newResult(ctx context.Context, err error, last *Command, start time.Time) *Result {
+	result := &Result{Duration: time.Since(start)}
+	if err == nil {
+		return result
+	}
+
+	var status interp.ExitStatus
+	switch {
+	case errors.As(err, &status):
+		result.ExitCode = int(status)
+	default:
+		result.ExitCode = 1
+	}
+
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		result.Canceled = true
+	}
+
+	if result.ExitCode > 128 && result.ExitCode < 128+65 {
+		result.Signal = syscall.Signal(result.ExitCode - 128)
+	}
+
+	if last != nil {
+		if last.ExitCode == 0 {
+			last.ExitCode = result.ExitCode
+		}
+		result.FailedCommand = last
+	}
+
+	return result
+}
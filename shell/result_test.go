@@ -0,0 +1,118 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_Result(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		wantExitCode   int
+		wantParseError bool
+		wantSignal     syscall.Signal
+		wantFailedLine uint
+		wantFailedArgs string
+	}{
+		{
+			name:    "success",
+			content: "echo ok",
+		},
+		{
+			name:           "exit code",
+			content:        "echo start\nexit 3",
+			wantExitCode:   3,
+			wantFailedLine: 2,
+			wantFailedArgs: "exit 3",
+		},
+		{
+			name:           "errexit",
+			content:        "set -e\necho one\nfalse\necho two",
+			wantExitCode:   1,
+			wantFailedLine: 3,
+			wantFailedArgs: "false",
+		},
+		{
+			name:           "signal convention",
+			content:        "exit 130",
+			wantExitCode:   130,
+			wantSignal:     syscall.SIGINT,
+			wantFailedLine: 1,
+			wantFailedArgs: "exit 130",
+		},
+		{
+			name:           "parse error",
+			content:        "if then",
+			wantExitCode:   2,
+			wantParseError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := Script{Content: tt.content, Name: "test.sh"}
+			logger := log.New("test")
+			logger.SetOutput(&bytes.Buffer{})
+
+			result, _ := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{}, logger)
+			if result == nil {
+				t.Fatal("RunWithOptions() returned nil result")
+			}
+
+			if result.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantExitCode)
+			}
+			if result.ParseError != tt.wantParseError {
+				t.Errorf("ParseError = %v, want %v", result.ParseError, tt.wantParseError)
+			}
+			if result.Signal != tt.wantSignal {
+				t.Errorf("Signal = %v, want %v", result.Signal, tt.wantSignal)
+			}
+			if result.Success() != (tt.wantExitCode == 0) {
+				t.Errorf("Success() = %v, want %v", result.Success(), tt.wantExitCode == 0)
+			}
+
+			if tt.wantFailedArgs == "" {
+				if result.FailedCommand != nil {
+					t.Errorf("FailedCommand = %v, want nil", result.FailedCommand)
+				}
+				return
+			}
+			if result.FailedCommand == nil {
+				t.Fatal("FailedCommand is nil")
+			}
+			if got := quoteArgs(result.FailedCommand.Args); got != tt.wantFailedArgs {
+				t.Errorf("FailedCommand.Args = %q, want %q", got, tt.wantFailedArgs)
+			}
+			if result.FailedCommand.Line != tt.wantFailedLine {
+				t.Errorf("FailedCommand.Line = %d, want %d", result.FailedCommand.Line, tt.wantFailedLine)
+			}
+			if result.FailedCommand.File != "test.sh" {
+				t.Errorf("FailedCommand.File = %q, want %q", result.FailedCommand.File, "test.sh")
+			}
+		})
+	}
+}
+
+func TestRunWithOptions_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	script := Script{Content: "echo never", Name: "test.sh"}
+	result, err := RunWithOptions(ctx, script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{}, log.New("test"))
+	if err == nil {
+		t.Fatal("RunWithOptions() should fail with a cancelled context")
+	}
+	if !result.Canceled {
+		t.Error("Canceled should be true")
+	}
+	if result.ExitCode == 0 {
+		t.Error("ExitCode should be non-zero")
+	}
+}
@@ -0,0 +1,90 @@
+package shell
+
+import (
+	"context"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/installable-sh/lib/log"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Session runs several scripts in turn in one interpreter, so that
+// variables, functions and the working directory left by one script are
+// seen by the next. For example, a prelude can define helper functions
+// for an installer, and a verification script can inspect its results.
+type Session struct {
+	in *interpreter
+}
+
+// Snapshot is a copy of a session's shell state at a point in time.
+type Snapshot struct {
+	Vars  map[string]Var
+	Funcs []string // sorted function names
+}
+
+// Var is a shell variable. Arrays are represented by their first element.
+type Var struct {
+	Value    string
+	Exported bool
+}
+
+// NewSession creates a session whose scripts share the given positional
+// arguments and I/O streams. Options.Params is not supported, since each
+// script would declare its own parameters.
+// Debug output is controlled by the logger's debug level.
+func NewSession(args []string, stdin io.Reader, stdout, stderr io.Writer, opts Options, logger log.DebugLogger) (*Session, error) {
+	if opts.Params {
+		return nil, logger.Errorf("parameters are not supported in sessions")
+	}
+	in, err := newInterpreter(args, stdin, stdout, stderr, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{in: in}, nil
+}
+
+// Run executes a script in the session. If args is non-nil, it replaces
+// the positional arguments; otherwise those left by the previous script
+// are kept. The result describes this script only.
+func (s *Session) Run(ctx context.Context, script Script, args []string) (*Result, error) {
+	start := time.Now()
+	if args != nil {
+		if err := interp.Params(append([]string{"--"}, args...)...)(s.in.runner); err != nil {
+			return &Result{ExitCode: 1, Duration: time.Since(start)}, err
+		}
+	}
+	return s.in.run(ctx, script, start)
+}
+
+// Reset discards all state left by previous scripts, returning the
+// session to how it was created.
+func (s *Session) Reset() {
+	s.in.logger.Debugf("Resetting session")
+	s.in.runner.Reset()
+	if s.in.traps != nil {
+		s.in.traps.reset()
+	}
+}
+
+// Close releases resources held by the session. It must not be used
+// afterwards.
+func (s *Session) Close() {
+	s.in.close()
+}
+
+// Snapshot returns a copy of the session's current variables and
+// functions.
+func (s *Session) Snapshot() Snapshot {
+	r := s.in.runner
+	snap := Snapshot{Vars: map[string]Var{}}
+	for name, vr := range r.Vars {
+		if vr.IsSet() {
+			snap.Vars[name] = Var{Value: vr.String(), Exported: vr.Exported}
+		}
+	}
+	snap.Funcs = slices.Sorted(maps.Keys(r.Funcs))
+	return snap
+}
@@ -0,0 +1,114 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestSession(t *testing.T) {
+	var stdout bytes.Buffer
+	session, err := NewSession([]string{"v1"}, strings.NewReader(""), &stdout, &bytes.Buffer{}, Options{}, log.New("test"))
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+	ctx := context.Background()
+
+	prelude := Script{Name: "prelude.sh", Content: `
+info() { echo "[info] $*"; }
+export PREFIX=/opt/tool
+COUNT=1`}
+	if _, err := session.Run(ctx, prelude, nil); err != nil {
+		t.Fatalf("Run(prelude) error: %v", err)
+	}
+
+	installer := Script{Name: "install.sh", Content: `info "installing $1 to $PREFIX"; COUNT=$((COUNT + 1))`}
+	if _, err := session.Run(ctx, installer, nil); err != nil {
+		t.Fatalf("Run(installer) error: %v", err)
+	}
+
+	snap := session.Snapshot()
+	if got := snap.Vars["COUNT"].Value; got != "2" {
+		t.Errorf("COUNT = %q, want %q", got, "2")
+	}
+	if v := snap.Vars["PREFIX"]; v.Value != "/opt/tool" || !v.Exported {
+		t.Errorf("PREFIX = %+v, want exported /opt/tool", v)
+	}
+	if !slices.Contains(snap.Funcs, "info") {
+		t.Errorf("Funcs = %v, want info", snap.Funcs)
+	}
+
+	verify := Script{Name: "verify.sh", Content: `[ "$COUNT" = 2 ] && info "verified $1"`}
+	result, err := session.Run(ctx, verify, []string{"v2"})
+	if err != nil {
+		t.Fatalf("Run(verify) error: %v", err)
+	}
+	if !result.Success() {
+		t.Errorf("verify result = %+v", result)
+	}
+
+	want := "[info] installing v1 to /opt/tool\n[info] verified v2\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+}
+
+func TestSession_Reset(t *testing.T) {
+	var stdout bytes.Buffer
+	session, err := NewSession(nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, Options{}, log.New("test"))
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := session.Run(ctx, Script{Name: "a.sh", Content: "X=1; f() { :; }"}, nil); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	session.Reset()
+
+	snap := session.Snapshot()
+	if _, ok := snap.Vars["X"]; ok {
+		t.Error("X should be unset after Reset")
+	}
+	if len(snap.Funcs) != 0 {
+		t.Errorf("Funcs = %v, want none after Reset", snap.Funcs)
+	}
+
+	if _, err := session.Run(ctx, Script{Name: "b.sh", Content: `echo "x=${X:-unset}"`}, nil); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := stdout.String(); got != "x=unset\n" {
+		t.Errorf("stdout = %q, want %q", got, "x=unset\n")
+	}
+}
+
+func TestSession_FailedStep(t *testing.T) {
+	session, err := NewSession(nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{}, log.New("test"))
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+	ctx := context.Background()
+
+	result, err := session.Run(ctx, Script{Name: "fail.sh", Content: "false"}, nil)
+	if err == nil || result.FailedCommand == nil || result.FailedCommand.File != "fail.sh" {
+		t.Fatalf("Run(fail.sh) = %+v, %v", result, err)
+	}
+
+	result, err = session.Run(ctx, Script{Name: "ok.sh", Content: "true"}, nil)
+	if err != nil || result.FailedCommand != nil {
+		t.Errorf("Run(ok.sh) = %+v, %v; state from the failed step leaked", result, err)
+	}
+}
+
+func TestNewSession_Params(t *testing.T) {
+	logger := log.New("test")
+	logger.SetOutput(&bytes.Buffer{})
+	_, err := NewSession(nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{Params: true}, logger)
+	if err == nil {
+		t.Error("NewSession() should reject Params")
+	}
+}
@@ -2,55 +2,572 @@ package shell
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
-	"strings"
+	"slices"
+	"time"
 
+	"github.com/hashicorp/go-retryablehttp"
 	"github.com/installable-sh/lib/log"
 	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/interp"
-	"mvdan.cc/sh/v3/syntax"
 )
 
 // Script represents a shell script to execute.
 type Script struct {
 	Content string
 	Name    string
+
+	// URL is where the script was fetched from, if anywhere.
+	// It is used to resolve relative `source` paths.
+	URL string
+}
+
+// Options configures how a script is run.
+type Options struct {
+	// Trace emits each executed command with its expanded arguments and
+	// position, similar to `set -x`, as a line starting with "+". External
+	// commands also get a line starting with "-" giving their exit status
+	// and duration. Builtins and functions run inside the interpreter,
+	// which reports nothing when they return, so they get only the "+"
+	// line. Trace lines go to TraceWriter if set, otherwise to the
+	// logger's debug output.
+	Trace       bool
+	TraceWriter io.Writer
+
+	// Dialect selects the shell language the script is parsed as.
+	// By default it is detected from the script's shebang.
+	Dialect Dialect
+
+	// StrictPOSIX rejects scripts that use features beyond POSIX sh,
+	// regardless of the dialect they declare.
+	StrictPOSIX bool
+
+	// Coreutils provides in-process implementations of common utilities
+	// such as cat, mkdir, cp and grep, used when the binary is not on
+	// PATH. This lets scripts run in scratch containers. Options they do
+	// not implement, including all long options, fail with status 2.
+	Coreutils bool
+
+	// HTTPClient, if set, serves curl and wget invocations in-process
+	// with the given client, such as one from fetch.NewClient, so nested
+	// downloads share its certificates, retries and proxy settings.
+	HTTPClient *retryablehttp.Client
+
+	// Escalation controls how sudo and doas invocations are handled.
+	// If EscalationHook is set, escalated commands are passed to it
+	// instead. Escalated commands are recorded in Result.Escalations.
+	Escalation     EscalationMode
+	EscalationHook EscalationHook
+
+	// RemoteSources resolves relative `source` and `.` paths against
+	// Script.URL and fetches remote files with the fetch package, using
+	// HTTPClient or a client from fetch.NewClient.
+	RemoteSources bool
+
+	// Record captures every external command execution into the given
+	// recording. Replay serves external commands from a recording instead
+	// of executing them. They cannot be used together. Commands served
+	// in-process, such as by Coreutils or HTTPClient, are neither recorded
+	// nor replayed: they run as usual.
+	Record *Recording
+	Replay *Recording
+
+	// Mocks serves external commands from a registry of fake commands
+	// and records every call, for unit-testing scripts.
+	Mocks *Mocks
+
+	// CaptureEnv reports the exported variables and functions the script
+	// left behind, diffed against the starting environment, in
+	// Result.Env.
+	CaptureEnv bool
+
+	// Answers feeds scripted responses to prompts read by the script,
+	// for running interactive installers unattended.
+	Answers *Answers
+
+	// Signals delivers signals to the script, typically from
+	// signal.Notify. SIGINT, SIGTERM and SIGHUP stop the script unless it
+	// ignores them with `trap '' SIG`: running commands are sent the
+	// signal and killed if they outlive a short grace period. The
+	// script's trap for the signal and then its EXIT trap run, bounded by
+	// TrapTimeout (5 seconds by default), which also applies when ctx is
+	// cancelled. Result.Signal reports the signal.
+	Signals     <-chan os.Signal
+	TrapTimeout time.Duration
+
+	// PTY runs the script with a pseudo-terminal as its stdin, stdout and
+	// stderr, for installers that behave differently without a TTY. It is
+	// only supported on Linux. Terminal output, including stderr, is
+	// copied to stdout, and to Transcript in the asciicast v2 format if
+	// set. Stdin is written to the terminal's input, followed by an end
+	// of file, and is not echoed to its output. It cannot be used with
+	// Answers.
+	PTY        bool
+	Transcript io.Writer
+
+	// CaptureOutput keeps the last CaptureOutput bytes of the script's
+	// output in Result.Stdout, Result.Stderr and Result.Output, for
+	// showing the tail of a failed run.
+	CaptureOutput int
+
+	// LinePrefix is written at the start of every line of stdout and
+	// stderr, such as "[install.sh] ". Lines from the two streams do not
+	// interleave. Captured output is not prefixed.
+	LinePrefix string
+
+	// Limits sets resource limits on every external command, which
+	// requires sh. Commands stopped for exceeding the CPU time limit are
+	// listed in Result.LimitViolations; the other limits make calls fail
+	// within the command and are not listed.
+	Limits *Limits
+
+	// Isolation runs external commands in Linux namespaces, against a
+	// throwaway view of the filesystem. It cannot be combined with
+	// Coreutils or HTTPClient, whose commands run in-process.
+	Isolation *Isolation
+
+	// TrackChanges lists the files the script created, modified or
+	// deleted in Result.Changes. Files the interpreter writes, such as
+	// with redirections, and those written by the in-process Coreutils
+	// and HTTPClient commands are always tracked; changes made by external
+	// commands are found by scanning TrackRoots before and after the run.
+	// Every regular file in TrackRoots is hashed, so keep them narrow.
+	TrackChanges bool
+	TrackRoots   []string
+
+	// BackupDir, with TrackChanges, keeps copies of the tracked files
+	// that the script modifies or deletes, for NewUninstall to restore.
+	BackupDir string
+
+	// Params validates args and the environment against the parameters
+	// the script declares in its header comment block, as described by
+	// ParseSchema, and assigns them to shell variables. Args of -h or
+	// --help print the script's help to stdout instead of running it.
+	// Invalid args fail with status 2. NewSession does not support it.
+	Params bool
+
+	// Checkpoints provides the checkpoint command, which records the
+	// script's progress so that a rerun skips the sections it completed.
+	// Skipped sections are listed in Result.Skipped.
+	Checkpoints *Checkpoints
+
+	// Telemetry emits a span for the script, with a child span for every
+	// external command, to an exporter such as an OTLPFileExporter.
+	Telemetry *Telemetry
 }
 
 // Run executes a shell script with custom I/O streams.
+// The script is parsed as bash whatever its shebang; RunWithOptions
+// detects the dialect by default.
 // Debug output is controlled by the logger's debug level.
 func Run(ctx context.Context, script Script, args []string, stdin io.Reader, stdout, stderr io.Writer, logger log.DebugLogger) error {
-	logger.Debugf("Parsing script: %s (%d bytes)", script.Name, len(script.Content))
-	parser := syntax.NewParser()
-	prog, err := parser.Parse(strings.NewReader(script.Content), script.Name)
+	_, err := RunWithOptions(ctx, script, args, stdin, stdout, stderr, Options{Dialect: DialectBash}, logger)
+	return err
+}
+
+// RunWithOptions executes a shell script like Run, with additional options.
+// The returned Result is never nil and describes how the script exited;
+// the error is the same one Run would return.
+// Debug output is controlled by the logger's debug level.
+func RunWithOptions(ctx context.Context, script Script, args []string, stdin io.Reader, stdout, stderr io.Writer, opts Options, logger log.DebugLogger) (*Result, error) {
+	start := time.Now()
+
+	var vars map[string]string
+	if opts.Params {
+		schema, err := ParseSchema(script.Content)
+		if err != nil {
+			return &Result{ExitCode: 2, Duration: time.Since(start)}, logger.Errorf("parameter schema error: %w", err)
+		}
+		if schema != nil {
+			if WantsHelp(args) {
+				_, err := io.WriteString(orDiscard(stdout), schema.Help(script.Name))
+				return &Result{Duration: time.Since(start)}, err
+			}
+			vars, args, err = schema.Parse(args, os.Getenv)
+			if err != nil {
+				_, _ = fmt.Fprintf(orDiscard(stderr), "%s: %v\nTry '%s --help' for more information.\n", script.Name, err, script.Name)
+				return &Result{ExitCode: 2, Duration: time.Since(start)}, logger.Errorf("argument error: %w", err)
+			}
+			logger.Debugf("Script parameters: %v", vars)
+		}
+	}
+
+	in, err := newInterpreter(args, stdin, stdout, stderr, opts, logger)
 	if err != nil {
-		return logger.Errorf("parse error: %w", err)
+		return &Result{ExitCode: 1, Duration: time.Since(start)}, err
+	}
+	in.vars = vars
+	result, err := in.run(ctx, script, start)
+	in.close()
+	if in.output != nil {
+		// Terminal output is only complete once the terminal is closed.
+		in.output.fill(result)
+	}
+	return result, err
+}
+
+// interpreter is an interp.Runner configured from Options. Its handlers
+// refer to the script currently being run, so that it can run several
+// scripts in turn.
+type interpreter struct {
+	runner      *interp.Runner
+	script      *Script
+	opts        Options
+	recorder    *commandRecorder
+	esc         *escalator
+	answers     *answerer
+	terminal    *terminal
+	output      *outputCapture
+	limiter     *limiter
+	tracker     *changeTracker
+	checkpoints *checkpointer
+	spans       *spanRecorder
+	vars        map[string]string // assigned before the next script runs
+	hooks       []execHook
+	traps       *traps
+	startEnv    map[string]string
+	logger      log.DebugLogger
+}
+
+func newInterpreter(args []string, stdin io.Reader, stdout, stderr io.Writer, opts Options, logger log.DebugLogger) (*interpreter, error) {
+	if opts.Record != nil && opts.Replay != nil {
+		return nil, logger.Errorf("cannot record and replay at the same time")
+	}
+	if opts.PTY && opts.Answers != nil {
+		return nil, logger.Errorf("cannot answer prompts on a pseudo-terminal")
+	}
+	if opts.Isolation != nil && (opts.Coreutils || opts.HTTPClient != nil) {
+		return nil, logger.Errorf("cannot isolate in-process commands")
+	}
+
+	in := &interpreter{script: &Script{}, opts: opts, logger: logger}
+
+	if opts.LinePrefix != "" {
+		stdout, stderr = prefixWriters(opts.LinePrefix, orDiscard(stdout), orDiscard(stderr))
+	}
+	if opts.CaptureOutput > 0 {
+		in.output = newOutputCapture(opts.CaptureOutput)
+		stdout, stderr = in.output.tee(orDiscard(stdout), orDiscard(stderr))
 	}
-	logger.Debugf("Parsed %d statements", len(prog.Stmts))
 
 	// Prepend "--" to args to prevent them from being interpreted as shell options
 	params := append([]string{"--"}, args...)
 	logger.Debugf("Script arguments: %v", args)
 
+	in.recorder = &commandRecorder{script: in.script}
+	callHandlers := []interp.CallHandlerFunc{in.recorder.call}
+	execMiddlewares := []func(interp.ExecHandlerFunc) interp.ExecHandlerFunc{in.recorder.exec}
+
+	if opts.Trace {
+		logger.Debugf("Execution tracing enabled")
+		t := newTracer(in.script, opts.TraceWriter, logger)
+		callHandlers = append(callHandlers, t.call)
+		execMiddlewares = append(execMiddlewares, t.exec)
+	}
+
+	if opts.Checkpoints != nil {
+		logger.Debugf("Recording checkpoints in %s", opts.Checkpoints.File)
+		c, err := newCheckpointer(in.script, *opts.Checkpoints, logger)
+		if err != nil {
+			return nil, logger.Errorf("checkpoints error: %w", err)
+		}
+		in.checkpoints = c
+		execMiddlewares = append(execMiddlewares, c.exec)
+	}
+
+	if opts.Telemetry != nil {
+		logger.Debugf("Recording spans")
+		r, err := newSpanRecorder(in.script, *opts.Telemetry)
+		if err != nil {
+			return nil, logger.Errorf("telemetry error: %w", err)
+		}
+		in.spans = r
+		execMiddlewares = append(execMiddlewares, r.exec)
+	}
+
+	in.esc = newEscalator(in.script, opts.Escalation, opts.EscalationHook, logger)
+	execMiddlewares = append(execMiddlewares, in.esc.exec)
+
+	// Mocks go before in-process commands so nothing runs at all.
+	if opts.Mocks != nil {
+		logger.Debugf("Serving external commands from mocks")
+		execMiddlewares = append(execMiddlewares, opts.Mocks.exec)
+	}
+
+	if opts.HTTPClient != nil {
+		logger.Debugf("Serving curl and wget in-process")
+		execMiddlewares = append(execMiddlewares, downloadMiddleware(opts.HTTPClient, logger))
+	}
+
+	if opts.Answers != nil {
+		logger.Debugf("Answering prompts from %d answers", len(opts.Answers.Rules))
+		answers, err := newAnswerer(opts.Answers, in.script, stdin, logger)
+		if err != nil {
+			return nil, logger.Errorf("answers error: %w", err)
+		}
+		in.answers = answers
+		stdin, stdout, stderr = answers.pr, answers.writer(stdout), answers.writer(stderr)
+		callHandlers = append(callHandlers, answers.call)
+		// Every exec handler after the recorder sees the original stdin.
+		execMiddlewares = slices.Insert(execMiddlewares, 1, answers.exec)
+	}
+
+	if opts.PTY {
+		t, err := openTerminal(stdout, logger)
+		if err != nil {
+			return nil, logger.Errorf("terminal error: %w", err)
+		}
+		if err := t.start(stdin, stdout, opts.Transcript); err != nil {
+			t.close()
+			return nil, logger.Errorf("terminal error: %w", err)
+		}
+		in.terminal = t
+		stdin, stdout, stderr = t.tty, t.tty, t.tty
+	}
+
+	if opts.Signals != nil {
+		logger.Debugf("Forwarding signals to the script")
+		in.traps = &traps{}
+		callHandlers = append(callHandlers, in.traps.call)
+	}
+
+	var openMiddlewares []func(interp.OpenHandlerFunc) interp.OpenHandlerFunc
+	var runnerOpts []interp.RunnerOption
+
+	if opts.RemoteSources {
+		logger.Debugf("Resolving sourced scripts against their origin")
+		sources, err := newRemoteSources(in.script, opts.HTTPClient, logger)
+		if err != nil {
+			return nil, logger.Errorf("remote sources error: %w", err)
+		}
+		callHandlers = append(callHandlers, sources.call)
+		openMiddlewares = append(openMiddlewares, sources.open)
+	}
+
+	// Isolation maps paths after remote sources are fetched.
+	if opts.Isolation != nil {
+		logger.Debugf("Isolating commands: %+v", *opts.Isolation)
+		iso, err := newIsolator(*opts.Isolation)
+		if err != nil {
+			return nil, logger.Errorf("isolation error: %w", err)
+		}
+		in.hooks = append(in.hooks, iso)
+		openMiddlewares = append(openMiddlewares, iso.open)
+		runnerOpts = append(runnerOpts, interp.StatHandler(iso.stat), interp.ReadDirHandler2(iso.readDir))
+	}
+
+	// Limits wrap isolation, so they also apply to the commands run in
+	// the namespaces.
+	if opts.Limits != nil {
+		logger.Debugf("Applying resource limits: %+v", *opts.Limits)
+		l, err := newLimiter(in.script, *opts.Limits)
+		if err != nil {
+			return nil, logger.Errorf("limits error: %w", err)
+		}
+		in.limiter = l
+		in.hooks = append(in.hooks, l)
+	}
+
+	// Tracking sees host paths, after isolation maps them.
+	if opts.TrackChanges {
+		logger.Debugf("Tracking file changes under %v", opts.TrackRoots)
+		tracker, err := newChangeTracker(opts.TrackRoots, opts.BackupDir)
+		if err != nil {
+			return nil, logger.Errorf("tracking error: %w", err)
+		}
+		in.tracker = tracker
+		openMiddlewares = append(openMiddlewares, tracker.open)
+	}
+
+	if opts.Coreutils {
+		logger.Debugf("In-process core utilities enabled")
+		execMiddlewares = append(execMiddlewares, coreutilsMiddleware)
+	}
+
+	// Recording captures the streams of real processes, so it replaces
+	// execution as the innermost handler, and replay serves exactly what
+	// it recorded.
+	if opts.Record != nil {
+		logger.Debugf("Recording command executions")
+		execMiddlewares = append(execMiddlewares, opts.Record.record)
+	} else if opts.Replay != nil {
+		logger.Debugf("Replaying %d recorded commands", len(opts.Replay.Commands))
+		execMiddlewares = append(execMiddlewares, opts.Replay.replay)
+	} else if opts.Signals != nil || opts.Answers != nil || len(in.hooks) > 0 {
+		// Commands are run by execCommand so that they are sent the
+		// signal that stopped the script, read the stdin chosen by
+		// execCtx and run the exec hooks.
+		execMiddlewares = append(execMiddlewares, execHandler)
+	}
+
 	logger.Debugf("Creating shell interpreter")
-	runner, err := interp.New(
+	runnerOpts = append(runnerOpts,
 		interp.StdIO(stdin, stdout, stderr),
 		interp.Env(expand.ListEnviron(os.Environ()...)),
 		interp.Params(params...),
+		interp.CallHandler(chainCallHandlers(callHandlers)),
+		interp.ExecHandlers(execMiddlewares...),
+		interp.OpenHandler(chainOpenHandlers(openMiddlewares)),
 	)
+	runner, err := interp.New(runnerOpts...)
+	if err != nil {
+		return nil, logger.Errorf("interpreter error: %w", err)
+	}
+	in.runner = runner
+	if in.traps != nil {
+		in.traps.runner = runner
+	}
+
+	if opts.CaptureEnv {
+		if err := in.captureStartEnv(); err != nil {
+			return nil, logger.Errorf("interpreter error: %w", err)
+		}
+	}
+
+	return in, nil
+}
+
+// run parses and executes a script, keeping any state left by earlier runs.
+func (in *interpreter) run(ctx context.Context, script Script, start time.Time) (*Result, error) {
+	*in.script = script
+	if in.spans == nil {
+		return in.execute(ctx, start)
+	}
+	in.spans.start(start)
+	result, err := in.execute(ctx, start)
+	if err := in.spans.finish(ctx, result); err != nil {
+		in.logger.Debugf("Exporting spans failed: %v", err)
+	}
+	return result, err
+}
+
+// execute parses and executes the script set by run.
+func (in *interpreter) execute(ctx context.Context, start time.Time) (*Result, error) {
+	logger := in.logger
+	script := *in.script
+	if in.output != nil {
+		in.output.reset()
+	}
+
+	logger.Debugf("Parsing script: %s (%d bytes)", script.Name, len(script.Content))
+	prog, dialect, err := parseScript(script, in.opts.Dialect, in.opts.StrictPOSIX)
 	if err != nil {
-		return logger.Errorf("interpreter error: %w", err)
+		result := &Result{ExitCode: 2, ParseError: true, Duration: time.Since(start)}
+		return result, logger.Errorf("parse error: %w", err)
+	}
+	logger.Debugf("Parsed %d statements as %s", len(prog.Stmts), dialect)
+
+	if len(in.vars) > 0 {
+		assigns, err := assignStmts(in.vars, dialect)
+		if err != nil {
+			return &Result{ExitCode: 2, Duration: time.Since(start)}, logger.Errorf("parameter error: %w", err)
+		}
+		prog.Stmts = append(assigns, prog.Stmts...)
+		in.vars = nil
+	}
+
+	var skipped []string
+	if in.checkpoints != nil {
+		skipped, err = in.checkpoints.start(prog)
+		if err != nil {
+			return &Result{ExitCode: 1, Duration: time.Since(start)}, logger.Errorf("checkpoints error: %w", err)
+		}
+		if len(skipped) > 0 {
+			logger.Debugf("Skipping completed sections: %v", skipped)
+		}
+	}
+
+	if in.tracker != nil {
+		in.tracker.start()
+		ctx = context.WithValue(ctx, trackerKey{}, in.tracker)
 	}
 
 	logger.Debugf("Executing script")
-	err = runner.Run(ctx, prog)
+	if len(in.hooks) > 0 {
+		ctx = withExecHooks(ctx, in.hooks)
+	}
+	if in.traps != nil {
+		var sig os.Signal
+		sig, err = in.runWithSignals(ctx, prog, dialect)
+		if sig != nil {
+			logger.Debugf("Script stopped by %s", sig)
+		}
+	} else {
+		err = in.runner.Run(ctx, prog)
+	}
 	if err != nil {
 		// Don't wrap execution errors - just log them (they may be ExitStatus)
 		logger.Debugf("Script exited: %v", err)
 	} else {
 		logger.Debugf("Script completed successfully")
 	}
+	if in.checkpoints != nil {
+		if ferr := in.checkpoints.finish(err); ferr != nil {
+			err = logger.Errorf("checkpoints error: %w", ferr)
+		}
+	}
 
-	return err
+	result := newResult(ctx, err, in.recorder.lastCommand(), start)
+	result.Escalations = in.esc.escalated()
+	result.Skipped = skipped
+	if in.output != nil {
+		in.output.fill(result)
+	}
+	if in.tracker != nil {
+		result.Changes = in.tracker.changes()
+		logger.Debugf("Script changed %d files", len(result.Changes))
+	}
+	if in.limiter != nil {
+		result.LimitViolations = in.limiter.violated()
+		for _, v := range result.LimitViolations {
+			logger.Debugf("Command exceeded %s limit: %s", v.Limit, &v.Command)
+		}
+	}
+	if in.opts.CaptureEnv {
+		result.Env = in.envChanges()
+		logger.Debugf("Environment changes: %d set, %d unset, %d functions", len(result.Env.Set), len(result.Env.Unset), len(result.Env.Funcs))
+	}
+	logger.Debugf("Script finished: exit=%d duration=%s", result.ExitCode, result.Duration)
+	if result.FailedCommand != nil {
+		logger.Debugf("Failed command: %s", result.FailedCommand)
+	}
+
+	return result, err
+}
+
+// close releases resources held by the interpreter.
+func (in *interpreter) close() {
+	if in.answers != nil {
+		in.answers.close()
+	}
+	if in.terminal != nil {
+		in.terminal.close()
+	}
+}
+
+// chainCallHandlers combines call handlers so that each receives the
+// arguments returned by the previous one.
+func chainCallHandlers(handlers []interp.CallHandlerFunc) interp.CallHandlerFunc {
+	return func(ctx context.Context, args []string) ([]string, error) {
+		var err error
+		for _, h := range handlers {
+			args, err = h(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return args, nil
+	}
+}
+
+// chainOpenHandlers wraps the default open handler with middlewares, the
+// first of which is called first, like interp.ExecHandlers.
+func chainOpenHandlers(middlewares []func(interp.OpenHandlerFunc) interp.OpenHandlerFunc) interp.OpenHandlerFunc {
+	handler := interp.DefaultOpenHandler()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
 }
@@ -0,0 +1,266 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// defaultTrapTimeout bounds how long traps may run after a script is
+// stopped by a signal or cancellation.
+const defaultTrapTimeout = 5 * time.Second
+
+// trapSignals are the conditions whose traps are run by the shell package
+// rather than the interpreter, in the order they run.
+var trapSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+}
+
+// signalSpec normalizes a trap condition such as SIGINT, int or 2 to its
+// name without the SIG prefix.
+func signalSpec(spec string) string {
+	spec = strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	switch spec {
+	case "0":
+		return "EXIT"
+	case "1":
+		return "HUP"
+	case "2":
+		return "INT"
+	case "15":
+		return "TERM"
+	}
+	return spec
+}
+
+// signalName returns the trap condition for a signal, or "" if it is not
+// handled.
+func signalName(sig os.Signal) string {
+	for name, s := range trapSignals {
+		if s == sig {
+			return name
+		}
+	}
+	return ""
+}
+
+// traps holds the script's EXIT and signal traps. The interpreter only
+// supports EXIT and ERR traps and cannot run them once its context is
+// cancelled, so these are handled here. Only traps set by the top-level
+// runner are kept; like the shell, a subshell does not replace them.
+type traps struct {
+	runner   *interp.Runner // the top-level runner
+	mu       sync.Mutex
+	commands map[string]string // "" ignores the signal
+}
+
+// inSubshell reports whether hc belongs to a subshell rather than the
+// top-level runner. HandlerContext does not export its runner, so it is
+// compared by reflection.
+func (t *traps) inSubshell(hc interp.HandlerContext) bool {
+	field := reflect.ValueOf(hc).FieldByName("runner")
+	if !field.IsValid() || field.Kind() != reflect.Pointer || t.runner == nil {
+		return false
+	}
+	return field.Pointer() != reflect.ValueOf(t.runner).Pointer()
+}
+
+func (t *traps) get(name string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cmd, ok := t.commands[name]
+	return cmd, ok
+}
+
+func (t *traps) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.commands = nil
+}
+
+// call implements the trap builtin for EXIT and the handled signals,
+// passing other conditions such as ERR on to the interpreter. Traps set
+// in subshells for EXIT and the handled signals are ignored.
+func (t *traps) call(ctx context.Context, args []string) ([]string, error) {
+	if args[0] != "trap" {
+		return args, nil
+	}
+	rest := args[1:]
+	if len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 || (len(rest) == 1 && rest[0] == "-p") {
+		t.print(interp.HandlerCtx(ctx))
+		return []string{"trap"}, nil
+	}
+	if strings.HasPrefix(rest[0], "-") && rest[0] != "-" {
+		return args, nil
+	}
+
+	// A single operand is a condition to reset, as is an action of "-".
+	action, specs := rest[0], rest[1:]
+	if len(rest) == 1 {
+		action, specs = "-", rest
+	}
+
+	subshell := t.inSubshell(interp.HandlerCtx(ctx))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.commands == nil {
+		t.commands = map[string]string{}
+	}
+	var passed []string
+	for _, spec := range specs {
+		name := signalSpec(spec)
+		if _, ok := trapSignals[name]; !ok && name != "EXIT" {
+			passed = append(passed, spec)
+			continue
+		}
+		if subshell {
+			continue
+		}
+		if action == "-" {
+			delete(t.commands, name)
+		} else {
+			t.commands[name] = action
+		}
+	}
+	if len(passed) == 0 {
+		return []string{"true"}, nil
+	}
+	return append([]string{"trap", action}, passed...), nil
+}
+
+// print lists the traps like `trap -p`.
+func (t *traps) print(hc interp.HandlerContext) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, 0, len(t.commands))
+	for name := range t.commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		cmd, err := syntax.Quote(t.commands[name], syntax.LangBash)
+		if err != nil {
+			cmd = fmt.Sprintf("%q", t.commands[name])
+		}
+		_, _ = fmt.Fprintf(hc.Stdout, "trap -- %s %s\n", cmd, name)
+	}
+}
+
+// stopSignalKey is the context key for the signal sent to commands when
+// the context is cancelled.
+type stopSignalKey struct{}
+
+// stopSignal returns the signal to send to running commands when ctx is
+// cancelled.
+func stopSignal(ctx context.Context) os.Signal {
+	if f, ok := ctx.Value(stopSignalKey{}).(func() os.Signal); ok {
+		if sig := f(); sig != nil {
+			return sig
+		}
+	}
+	return os.Interrupt
+}
+
+// runWithSignals runs prog, stopping it when one of Options.Signals is
+// received, and then runs the traps. It returns the signal that stopped
+// the script, if any.
+func (in *interpreter) runWithSignals(ctx context.Context, prog *syntax.File, dialect Dialect) (os.Signal, error) {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu         sync.Mutex
+		received   os.Signal
+		cancelTrap context.CancelFunc
+	)
+	runCtx = context.WithValue(runCtx, stopSignalKey{}, func() os.Signal {
+		mu.Lock()
+		defer mu.Unlock()
+		return received
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-in.opts.Signals:
+				name := signalName(sig)
+				if name == "" {
+					continue
+				}
+				if cmd, ok := in.traps.get(name); ok && cmd == "" {
+					in.logger.Debugf("Ignoring %s", sig)
+					continue
+				}
+				mu.Lock()
+				if received == nil {
+					in.logger.Debugf("Stopping script on %s", sig)
+					received = sig
+					cancel()
+				} else if cancelTrap != nil {
+					in.logger.Debugf("Stopping traps on %s", sig)
+					cancelTrap()
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+
+	err := in.runner.Run(runCtx, prog)
+
+	mu.Lock()
+	sig := received
+	trapCtx := ctx
+	if sig != nil || ctx.Err() != nil {
+		timeout := in.opts.TrapTimeout
+		if timeout <= 0 {
+			timeout = defaultTrapTimeout
+		}
+		trapCtx, cancelTrap = context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancelTrap()
+	}
+	mu.Unlock()
+
+	if sig != nil {
+		in.runTrap(trapCtx, signalName(sig), dialect)
+		err = interp.ExitStatus(128 + trapSignals[signalName(sig)])
+	}
+	in.runTrap(trapCtx, "EXIT", dialect)
+	return sig, err
+}
+
+// runTrap runs the named trap, if set. Like the shell, traps do not
+// change the script's exit status.
+func (in *interpreter) runTrap(ctx context.Context, name string, dialect Dialect) {
+	cmd, ok := in.traps.get(name)
+	if !ok || cmd == "" {
+		return
+	}
+	in.logger.Debugf("Running %s trap", name)
+	parser := syntax.NewParser(syntax.Variant(dialect.lang()))
+	prog, err := parser.Parse(strings.NewReader(cmd), name+" trap")
+	if err != nil {
+		in.logger.Debugf("%s trap: %v", name, err)
+		return
+	}
+	if err := in.runner.Run(ctx, prog); err != nil {
+		in.logger.Debugf("%s trap exited: %v", name, err)
+	}
+}
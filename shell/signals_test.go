@@ -0,0 +1,153 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_Signals(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		signal       os.Signal
+		wantStdout   string
+		wantExitCode int
+		wantSignal   syscall.Signal
+	}{
+		{
+			name:         "interrupt runs traps",
+			content:      "trap 'echo cleanup' EXIT\ntrap 'echo interrupted' INT\necho start\nsleep 5\necho unreachable",
+			signal:       syscall.SIGINT,
+			wantStdout:   "start\ninterrupted\ncleanup\n",
+			wantExitCode: 130,
+			wantSignal:   syscall.SIGINT,
+		},
+		{
+			name:         "terminate with numeric trap",
+			content:      "trap 'echo cleanup' 0\necho start\nsleep 5",
+			signal:       syscall.SIGTERM,
+			wantStdout:   "start\ncleanup\n",
+			wantExitCode: 143,
+			wantSignal:   syscall.SIGTERM,
+		},
+		{
+			name:       "ignored signal",
+			content:    "trap '' HUP\necho start\nsleep 0.5\necho done",
+			signal:     syscall.SIGHUP,
+			wantStdout: "start\ndone\n",
+		},
+		{
+			name:       "unhandled signal",
+			content:    "echo start\nsleep 0.5\necho done",
+			signal:     syscall.SIGQUIT,
+			wantStdout: "start\ndone\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signals := make(chan os.Signal, 1)
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				signals <- tt.signal
+			}()
+
+			var stdout bytes.Buffer
+			script := Script{Name: "test.sh", Content: tt.content}
+			start := time.Now()
+			result, _ := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, Options{Signals: signals}, log.New("test"))
+
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", got, tt.wantStdout)
+			}
+			if result.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantExitCode)
+			}
+			if result.Signal != tt.wantSignal {
+				t.Errorf("Signal = %v, want %v", result.Signal, tt.wantSignal)
+			}
+			if result.Canceled {
+				t.Error("Canceled = true, want false")
+			}
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("run took %s, want the child to be stopped", elapsed)
+			}
+		})
+	}
+}
+
+func TestRunWithOptions_TrapTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+
+	var stdout bytes.Buffer
+	script := Script{Name: "test.sh", Content: "trap 'echo cleanup; sleep 5; echo unreachable' EXIT\nsleep 5"}
+	opts := Options{Signals: make(chan os.Signal), TrapTimeout: 300 * time.Millisecond}
+	start := time.Now()
+	result, _ := RunWithOptions(ctx, script, nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, opts, log.New("test"))
+
+	if got := stdout.String(); got != "cleanup\n" {
+		t.Errorf("stdout = %q, want %q", got, "cleanup\n")
+	}
+	if !result.Canceled {
+		t.Error("Canceled = false, want true")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("run took %s, want traps bounded by TrapTimeout", elapsed)
+	}
+}
+
+func TestTraps(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantStdout string
+	}{
+		{
+			name:       "exit trap on success",
+			content:    "trap 'echo bye' EXIT\necho hi",
+			wantStdout: "hi\nbye\n",
+		},
+		{
+			name:       "list traps",
+			content:    "trap 'echo bye' EXIT SIGINT\ntrap",
+			wantStdout: "trap -- 'echo bye' EXIT\ntrap -- 'echo bye' INT\nbye\n",
+		},
+		{
+			name:       "reset traps",
+			content:    "trap 'echo bye' EXIT INT\ntrap - EXIT\ntrap INT\ntrap -p",
+			wantStdout: "",
+		},
+		{
+			name:       "err trap is passed through",
+			content:    "trap 'echo failed' ERR TERM\nfalse",
+			wantStdout: "failed\n",
+		},
+		{
+			name:       "subshell traps do not replace the script's",
+			content:    "trap 'echo main-exit' EXIT\n( trap 'echo sub-exit' EXIT INT; echo in-sub )\necho \"$(trap 'echo cmd-exit' EXIT; echo in-cmd)\"\ntrap",
+			wantStdout: "in-sub\nin-cmd\ntrap -- 'echo main-exit' EXIT\nmain-exit\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			script := Script{Name: "test.sh", Content: tt.content}
+			_, _ = RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, Options{Signals: make(chan os.Signal)}, log.New("test"))
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", got, tt.wantStdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/installable-sh/lib/fetch"
+	"github.com/installable-sh/lib/log"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// remoteSources resolves `source` and `.` against the URL a script was
+// fetched from, and fetches remote files through the fetch package.
+type remoteSources struct {
+	script *Script
+	client *retryablehttp.Client
+	logger log.DebugLogger
+}
+
+func newRemoteSources(script *Script, client *retryablehttp.Client, logger log.DebugLogger) (*remoteSources, error) {
+	s := &remoteSources{script: script, client: client, logger: logger}
+	if s.client == nil {
+		c, err := fetch.NewClient(logger)
+		if err != nil {
+			return nil, err
+		}
+		s.client = c
+	}
+	return s, nil
+}
+
+// call rewrites relative paths given to source and . into URLs relative to
+// the script's origin. Absolute paths stay local.
+func (s *remoteSources) call(_ context.Context, args []string) ([]string, error) {
+	if !isRemote(s.script.URL) || len(args) < 2 || (args[0] != "source" && args[0] != ".") {
+		return args, nil
+	}
+	name := args[1]
+	if name == "" || isRemote(name) || path.IsAbs(name) {
+		return args, nil
+	}
+	origin, err := url.Parse(s.script.URL)
+	if err != nil {
+		return args, nil
+	}
+	ref, err := url.Parse(name)
+	if err != nil {
+		return args, nil
+	}
+	resolved := origin.ResolveReference(ref).String()
+	s.logger.Debugf("Resolving %s %s against origin: %s", args[0], name, resolved)
+
+	rewritten := append([]string(nil), args...)
+	rewritten[1] = resolved
+	return rewritten, nil
+}
+
+// open fetches URLs opened for reading, such as those produced by call or
+// written directly as `. https://...`, and defers to next otherwise.
+func (s *remoteSources) open(next interp.OpenHandlerFunc) interp.OpenHandlerFunc {
+	return func(ctx context.Context, name string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+		if !isRemote(name) {
+			return next(ctx, name, flag, perm)
+		}
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errors.ErrUnsupported}
+		}
+		s.logger.Debugf("Fetching sourced script: %s", name)
+		script, err := fetch.Fetch(ctx, s.client, fetch.Options{URL: name}, s.logger)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		return readOnlyFile{strings.NewReader(script.Content)}, nil
+	}
+}
+
+func isRemote(name string) bool {
+	return strings.HasPrefix(name, "https://") || strings.HasPrefix(name, "http://")
+}
+
+// readOnlyFile adapts a reader to the io.ReadWriteCloser that open
+// handlers return.
+type readOnlyFile struct {
+	io.Reader
+}
+
+func (readOnlyFile) Write([]byte) (int, error) { return 0, errors.ErrUnsupported }
+func (readOnlyFile) Close() error              { return nil }
@@ -0,0 +1,104 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_RemoteSources(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/lib.sh", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`greet() { echo "hello from lib $1"; }`))
+	})
+	mux.HandleFunc("/common/util.sh", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`UTIL=loaded`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	localDir := t.TempDir()
+	localFile := filepath.Join(localDir, "local.sh")
+	if err := os.WriteFile(localFile, []byte("LOCAL=yes"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		content    string
+		wantStdout string
+		wantErr    bool
+	}{
+		{
+			name:       "relative source",
+			content:    `. ./lib.sh; greet one`,
+			wantStdout: "hello from lib one\n",
+		},
+		{
+			name:       "parent directory",
+			content:    `source ../common/util.sh; echo $UTIL`,
+			wantStdout: "loaded\n",
+		},
+		{
+			name:       "absolute URL",
+			content:    `. "$1/v1/lib.sh"; greet two`,
+			wantStdout: "hello from lib two\n",
+		},
+		{
+			name:       "absolute path stays local",
+			content:    `. "$2"; echo $LOCAL`,
+			wantStdout: "yes\n",
+		},
+		{
+			name:    "missing remote file",
+			content: `. ./missing.sh`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := log.New("test")
+			logger.SetOutput(&bytes.Buffer{})
+			script := Script{
+				Content: tt.content,
+				Name:    "install.sh",
+				URL:     server.URL + "/v1/install.sh",
+			}
+			var stdout, stderr bytes.Buffer
+
+			_, err := RunWithOptions(context.Background(), script, []string{server.URL, localFile}, strings.NewReader(""), &stdout, &stderr, Options{RemoteSources: true}, logger)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunWithOptions() error = %v, wantErr %v, stderr: %s", err, tt.wantErr, stderr.String())
+			}
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", got, tt.wantStdout)
+			}
+		})
+	}
+}
+
+func TestRunWithOptions_RemoteSourcesLocalScript(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "lib.sh"), []byte("LIB=local"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a remote origin, relative sources resolve locally as usual.
+	script := Script{Content: `cd "$1" && . ./lib.sh && echo $LIB`, Name: "test.sh"}
+	var stdout bytes.Buffer
+	_, err := RunWithOptions(context.Background(), script, []string{dir}, strings.NewReader(""), &stdout, &bytes.Buffer{}, Options{RemoteSources: true}, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	if got := stdout.String(); got != "local\n" {
+		t.Errorf("stdout = %q, want %q", got, "local\n")
+	}
+}
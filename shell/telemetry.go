@@ -0,0 +1,359 @@
+package shell
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Span is a timed operation in a trace, modeled on OpenTelemetry spans.
+// A run produces a span for the script with a child span for every
+// external command. IDs are lowercase hex, as in W3C trace context.
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string // empty for a root span
+	Name     string
+	Start    time.Time
+	End      time.Time
+
+	// Attributes hold string, int, int64, float64, bool and []string
+	// values, named after OpenTelemetry semantic conventions where one
+	// applies.
+	Attributes map[string]any
+
+	// Error is the error status message. It is empty if the operation
+	// succeeded.
+	Error string
+}
+
+// Duration returns how long the span lasted.
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// SpanExporter sends finished spans somewhere, such as a file or a
+// collector. It is called once per run with all of the run's spans, the
+// script span last.
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []Span) error
+}
+
+// RedactFunc rewrites the arguments of a command before they are recorded
+// in a span. It must not modify args.
+type RedactFunc func(args []string) []string
+
+// Telemetry configures the spans emitted for a run.
+type Telemetry struct {
+	// Exporter receives the spans once the script exits.
+	Exporter SpanExporter
+
+	// Redact rewrites the arguments recorded for each command. It
+	// defaults to RedactSecrets.
+	Redact RedactFunc
+
+	// Parent is a W3C traceparent header value, such as from the
+	// TRACEPARENT environment variable, making the script span a child
+	// of a span in another process.
+	Parent string
+
+	// Attributes are added to the script span.
+	Attributes map[string]any
+}
+
+// redacted replaces secrets in recorded arguments.
+const redacted = "[REDACTED]"
+
+// secretName matches the names of options, headers, variables and query
+// parameters that hold secrets.
+var secretName = regexp.MustCompile(`(?i)(pass(word|wd)?|secret|token|api[-_]?key|credential|private[-_]?key|^-*auth(orization)?$)`)
+
+// credentialOptions lists, by command, the options whose value is a user
+// name that may be followed by a password, as in "curl -u user:pass".
+var credentialOptions = map[string][]string{
+	"curl": {"-u", "--user", "-U", "--proxy-user"},
+	"wget": {"--user", "--http-user", "--ftp-user", "--proxy-user"},
+}
+
+// RedactSecrets redacts the values of options such as --password and
+// --token=..., the credentials given to curl and wget with options such
+// as -u and --user, headers such as "Authorization: ...", including the
+// values of -H and --header, assignments such as API_KEY=..., and
+// passwords and secret query parameters in URLs.
+func RedactSecrets(args []string) []string {
+	out := slices.Clone(args)
+	credentials := credentialOptions[path.Base(args[0])]
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if prefix := headerPrefix(arg); prefix != "" {
+			out[i] = prefix + redactHeader(arg[len(prefix):])
+			continue
+		}
+		if prefix := credentialPrefix(credentials, arg); prefix != "" {
+			out[i] = prefix + redacted
+			continue
+		}
+		if i+1 < len(args) {
+			switch {
+			case arg == "-H" || arg == "--header":
+				i++
+				out[i] = redactHeader(args[i])
+				continue
+			case slices.Contains(credentials, arg),
+				strings.HasPrefix(arg, "-") && !strings.Contains(arg, "=") && secretName.MatchString(arg):
+				i++
+				out[i] = redacted
+				continue
+			}
+		}
+		out[i] = redactArg(arg)
+	}
+	return out
+}
+
+// redactArg redacts an argument that is not the value of an option
+// RedactSecrets knows about.
+func redactArg(arg string) string {
+	switch {
+	case strings.HasPrefix(arg, "-") && strings.Contains(arg, "="):
+		name, _, _ := strings.Cut(arg, "=")
+		return redactValue(arg, name, len(name)+1)
+	case strings.Contains(arg, "://"):
+		return redactURL(arg)
+	}
+	if name, _, ok := strings.Cut(arg, ": "); ok && !strings.ContainsAny(name, " \t") {
+		return redactValue(arg, name, len(name)+2)
+	}
+	if name, _, ok := strings.Cut(arg, "="); ok && !strings.ContainsAny(name, " \t") {
+		return redactValue(arg, name, len(name)+1)
+	}
+	return arg
+}
+
+// headerPrefix returns the option at the start of arg if it is a header
+// option with its value attached, as in "--header=Name: value" or
+// "-HName: value".
+func headerPrefix(arg string) string {
+	switch {
+	case strings.HasPrefix(arg, "--header="):
+		return "--header="
+	case len(arg) > 2 && strings.HasPrefix(arg, "-H"):
+		return "-H"
+	}
+	return ""
+}
+
+// redactHeader redacts the value of a "Name: value" header if the name
+// looks like a secret.
+func redactHeader(header string) string {
+	name, value, ok := strings.Cut(header, ":")
+	if !ok || !secretName.MatchString(strings.TrimSpace(name)) {
+		return header
+	}
+	return header[:len(header)-len(strings.TrimLeft(value, " \t"))] + redacted
+}
+
+// credentialPrefix returns the option at the start of arg if it is one of
+// options with its value attached, as in "--user=me:pass" or "-ume:pass".
+func credentialPrefix(options []string, arg string) string {
+	if name, _, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(name, "--") {
+		if slices.Contains(options, name) {
+			return name + "="
+		}
+		return ""
+	}
+	if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && slices.Contains(options, arg[:2]) {
+		return arg[:2]
+	}
+	return ""
+}
+
+// redactValue redacts arg from offset on if name looks like a secret.
+func redactValue(arg, name string, offset int) string {
+	if !secretName.MatchString(name) {
+		return arg
+	}
+	return arg[:offset] + redacted
+}
+
+func redactURL(arg string) string {
+	u, err := url.Parse(arg)
+	if err != nil || u.Host == "" {
+		return arg
+	}
+	changed := false
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+		changed = true
+	}
+	query := u.Query()
+	for name := range query {
+		if secretName.MatchString(name) {
+			query.Set(name, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return arg
+	}
+	u.RawQuery = query.Encode()
+	s := u.String()
+	return strings.ReplaceAll(s, url.QueryEscape(redacted), redacted)
+}
+
+// RedactAll records only the command name.
+func RedactAll(args []string) []string {
+	return []string{args[0]}
+}
+
+// spanRecorder collects the spans of the script currently being run.
+// Commands in a pipeline run concurrently, so access is serialized.
+type spanRecorder struct {
+	opts     Telemetry
+	script   *Script
+	traceID  string // from Telemetry.Parent, if any
+	parentID string
+
+	mu    sync.Mutex
+	root  Span
+	spans []Span
+}
+
+func newSpanRecorder(script *Script, opts Telemetry) (*spanRecorder, error) {
+	if opts.Exporter == nil {
+		return nil, errors.New("no span exporter")
+	}
+	if opts.Redact == nil {
+		opts.Redact = RedactSecrets
+	}
+	r := &spanRecorder{opts: opts, script: script}
+	if opts.Parent != "" {
+		var err error
+		r.traceID, r.parentID, err = parseTraceparent(opts.Parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// parseTraceparent returns the trace and parent span IDs from a W3C
+// traceparent header value.
+func parseTraceparent(s string) (traceID, spanID string, err error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || !isHexID(parts[1], 16) || !isHexID(parts[2], 8) {
+		return "", "", fmt.Errorf("invalid traceparent: %q", s)
+	}
+	return parts[1], parts[2], nil
+}
+
+// isHexID returns true if s is a non-zero lowercase hex ID of n bytes.
+func isHexID(s string, n int) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == n && s == strings.ToLower(s) && strings.Trim(s, "0") != ""
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// start begins the script span.
+func (r *spanRecorder) start(start time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	traceID := r.traceID
+	if traceID == "" {
+		traceID = newID(16)
+	}
+	r.root = Span{
+		TraceID:    traceID,
+		SpanID:     newID(8),
+		ParentID:   r.parentID,
+		Name:       r.script.Name,
+		Start:      start,
+		Attributes: map[string]any{"script.name": r.script.Name},
+	}
+	if r.script.URL != "" {
+		r.root.Attributes["script.url"] = r.script.URL
+	}
+	for k, v := range r.opts.Attributes {
+		r.root.Attributes[k] = v
+	}
+	r.spans = nil
+}
+
+func (r *spanRecorder) exec(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		hc := interp.HandlerCtx(ctx)
+		start := time.Now()
+		err := next(ctx, args)
+		code := exitCode(err)
+		span := Span{
+			SpanID: newID(8),
+			Name:   args[0],
+			Start:  start,
+			End:    time.Now(),
+			Attributes: map[string]any{
+				"process.command":      args[0],
+				"process.command_args": r.opts.Redact(args),
+				"process.exit.code":    code,
+				"code.filepath":        r.script.Name,
+				"code.lineno":          int(hc.Pos.Line()),
+			},
+		}
+		if err != nil {
+			span.Error = commandError(code, err)
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		span.TraceID, span.ParentID = r.root.TraceID, r.root.SpanID
+		r.spans = append(r.spans, span)
+		return err
+	}
+}
+
+func commandError(code int, err error) string {
+	if code < 0 {
+		return err.Error()
+	}
+	return fmt.Sprintf("exit status %d", code)
+}
+
+// finish ends the script span and exports the run's spans.
+func (r *spanRecorder) finish(ctx context.Context, result *Result) error {
+	r.mu.Lock()
+	root, spans := r.root, r.spans
+	r.spans = nil
+	r.mu.Unlock()
+
+	root.End = root.Start.Add(result.Duration)
+	root.Attributes["process.exit.code"] = result.ExitCode
+	if result.Canceled {
+		root.Attributes["script.canceled"] = true
+	}
+	if result.Signal != 0 {
+		root.Attributes["script.signal"] = result.Signal.String()
+	}
+	switch {
+	case result.ParseError:
+		root.Error = "parse error"
+	case result.FailedCommand != nil:
+		root.Error = fmt.Sprintf("exit status %d at %s:%d", result.ExitCode, result.FailedCommand.File, result.FailedCommand.Line)
+	case result.ExitCode != 0:
+		root.Error = fmt.Sprintf("exit status %d", result.ExitCode)
+	}
+	return r.opts.Exporter.ExportSpans(context.WithoutCancel(ctx), append(spans, root))
+}
@@ -0,0 +1,153 @@
+package shell
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/installable-sh/lib/log"
+	"golang.org/x/term"
+)
+
+// Default terminal size when the caller's stdout is not a terminal.
+const (
+	defaultTerminalWidth  = 80
+	defaultTerminalHeight = 24
+)
+
+// eofChar is the terminal's default VEOF character, ^D.
+const eofChar = 0x04
+
+// terminalDrainTimeout bounds how long closing a terminal waits for
+// output that is still being written, such as by background processes.
+const terminalDrainTimeout = time.Second
+
+// terminal is a pseudo-terminal used as a script's stdin, stdout and
+// stderr. Its output is copied to the caller's writer and transcript.
+type terminal struct {
+	master, tty   *os.File
+	width, height int
+	logger        log.DebugLogger
+	done          chan struct{}
+}
+
+// openTerminal allocates a pseudo-terminal, sized like stdout if that is
+// a terminal.
+func openTerminal(stdout io.Writer, logger log.DebugLogger) (*terminal, error) {
+	width, height := defaultTerminalWidth, defaultTerminalHeight
+	if f, ok := stdout.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		if w, h, err := term.GetSize(int(f.Fd())); err == nil {
+			width, height = w, h
+		}
+	}
+	master, tty, err := openPTY(width, height)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Allocated %dx%d pseudo-terminal %s", width, height, tty.Name())
+	return &terminal{master: master, tty: tty, width: width, height: height, logger: logger, done: make(chan struct{})}, nil
+}
+
+// start copies the terminal's output to stdout and, if set, transcript,
+// and stdin, if set, to the terminal's input, followed by an end of file
+// once stdin is exhausted.
+func (t *terminal) start(stdin io.Reader, stdout, transcript io.Writer) error {
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if transcript != nil {
+		tw, err := newTranscript(transcript, t.width, t.height)
+		if err != nil {
+			return err
+		}
+		stdout = io.MultiWriter(stdout, tw)
+	}
+	go func() {
+		defer close(t.done)
+		// Reading fails with EIO once the terminal is closed.
+		_, _ = io.Copy(stdout, t.master)
+	}()
+	if stdin != nil {
+		go func() {
+			w := &lastByteWriter{w: t.master}
+			_, _ = io.Copy(w, stdin)
+			// VEOF ends a read; it takes a second one to read end of
+			// file after a partial line.
+			eof := []byte{eofChar}
+			if w.last != 0 && w.last != '\n' {
+				eof = append(eof, eofChar)
+			}
+			_, _ = t.master.Write(eof)
+		}()
+	}
+	return nil
+}
+
+// lastByteWriter remembers the last byte written through it.
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (w *lastByteWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.last = p[n-1]
+	}
+	return n, err
+}
+
+// close closes the terminal, waiting for its remaining output to be
+// copied.
+func (t *terminal) close() {
+	_ = t.tty.Close()
+	select {
+	case <-t.done:
+	case <-time.After(terminalDrainTimeout):
+		t.logger.Debugf("Terminal output still open after %s", terminalDrainTimeout)
+	}
+	_ = t.master.Close()
+}
+
+// transcript writes terminal output in the asciicast v2 format, a JSON
+// header line followed by one [time, "o", data] event per line.
+type transcript struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+}
+
+func newTranscript(w io.Writer, width, height int) (*transcript, error) {
+	t := &transcript{enc: json.NewEncoder(w), start: time.Now()}
+	header := struct {
+		Version   int               `json:"version"`
+		Width     int               `json:"width"`
+		Height    int               `json:"height"`
+		Timestamp int64             `json:"timestamp"`
+		Env       map[string]string `json:"env,omitempty"`
+	}{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: t.start.Unix(),
+	}
+	if v := os.Getenv("TERM"); v != "" {
+		header.Env = map[string]string{"TERM": v}
+	}
+	if err := t.enc.Encode(header); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *transcript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	event := []any{time.Since(t.start).Seconds(), "o", string(p)}
+	if err := t.enc.Encode(event); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,109 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_PTY(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pseudo-terminals are only supported on Linux")
+	}
+
+	var stdout, transcript bytes.Buffer
+	script := Script{Name: "test.sh", Content: `
+if [ -t 1 ]; then echo "stdout is a tty"; fi
+sh -c '[ -t 0 ] && [ -t 2 ] && echo "child has a tty"'
+echo "to stderr" >&2`}
+	opts := Options{PTY: true, Transcript: &transcript}
+	result, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &stdout, &bytes.Buffer{}, opts, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	if !result.Success() {
+		t.Errorf("result = %+v", result)
+	}
+
+	want := "stdout is a tty\r\nchild has a tty\r\nto stderr\r\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+
+	scanner := bufio.NewScanner(&transcript)
+	if !scanner.Scan() {
+		t.Fatal("transcript is empty")
+	}
+	var header struct {
+		Version int `json:"version"`
+		Width   int `json:"width"`
+		Height  int `json:"height"`
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatalf("invalid transcript header: %v", err)
+	}
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 {
+		t.Errorf("transcript header = %+v", header)
+	}
+	var output strings.Builder
+	for scanner.Scan() {
+		var event []any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid transcript event %s: %v", scanner.Text(), err)
+		}
+		if len(event) != 3 || event[1] != "o" {
+			t.Fatalf("transcript event = %v", event)
+		}
+		output.WriteString(event[2].(string))
+	}
+	if output.String() != want {
+		t.Errorf("transcript output = %q, want %q", output.String(), want)
+	}
+}
+
+func TestRunWithOptions_PTYStdin(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pseudo-terminals are only supported on Linux")
+	}
+
+	// Without an end of file after stdin, cat would wait for more input.
+	tests := []struct {
+		stdin, want string
+	}{
+		{stdin: "hello\n", want: "hello\r\ndone\r\n"},
+		{stdin: "hello", want: "hello\r\ndone\r\n"},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var stdout bytes.Buffer
+		script := Script{Name: "test.sh", Content: "cat | tr -d '\\n'; echo; echo done"}
+		result, err := RunWithOptions(ctx, script, nil, strings.NewReader(tt.stdin), &stdout, &bytes.Buffer{}, Options{PTY: true}, log.New("test"))
+		if err != nil {
+			t.Fatalf("RunWithOptions(%q) error: %v", tt.stdin, err)
+		}
+		if !result.Success() {
+			t.Errorf("RunWithOptions(%q) result = %+v", tt.stdin, result)
+		}
+		if got := stdout.String(); got != tt.want {
+			t.Errorf("RunWithOptions(%q) stdout = %q, want %q, with input not echoed", tt.stdin, got, tt.want)
+		}
+	}
+}
+
+func TestRunWithOptions_PTYWithAnswers(t *testing.T) {
+	logger := log.New("test")
+	logger.SetOutput(&bytes.Buffer{})
+	script := Script{Name: "test.sh", Content: "true"}
+	opts := Options{PTY: true, Answers: &Answers{}}
+	if _, err := RunWithOptions(context.Background(), script, nil, nil, &bytes.Buffer{}, &bytes.Buffer{}, opts, logger); err == nil {
+		t.Error("RunWithOptions() with PTY and Answers succeeded")
+	}
+}
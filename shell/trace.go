@@ -0,0 +1,91 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/installable-sh/lib/log"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// tracer emits `set -x` style lines for executed commands.
+// Commands in a pipeline run concurrently, so writes are serialized.
+type tracer struct {
+	script *Script
+	output io.Writer
+	logger log.DebugLogger
+	mu     sync.Mutex
+}
+
+func newTracer(script *Script, output io.Writer, logger log.DebugLogger) *tracer {
+	return &tracer{script: script, output: output, logger: logger}
+}
+
+// call traces every simple command, including builtins and functions.
+func (t *tracer) call(ctx context.Context, args []string) ([]string, error) {
+	hc := interp.HandlerCtx(ctx)
+	t.printf("+ %s: %s", t.position(hc.Pos), quoteArgs(args))
+	return args, nil
+}
+
+// exec traces the duration and exit status of external commands. The
+// interpreter has no hook for when builtins and functions return, so
+// they are only traced by call.
+func (t *tracer) exec(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		hc := interp.HandlerCtx(ctx)
+		start := time.Now()
+		err := next(ctx, args)
+		t.printf("- %s: %s exited %d after %s", t.position(hc.Pos), args[0], exitCode(err), time.Since(start).Round(time.Microsecond))
+		return err
+	}
+}
+
+func (t *tracer) position(pos syntax.Pos) string {
+	if !pos.IsValid() {
+		return t.script.Name
+	}
+	return fmt.Sprintf("%s:%d", t.script.Name, pos.Line())
+}
+
+func (t *tracer) printf(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.output != nil {
+		_, _ = fmt.Fprintf(t.output, format+"\n", args...)
+		return
+	}
+	t.logger.Debugf(format, args...)
+}
+
+// quoteArgs renders args so that they could be pasted back into a shell.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		q, err := syntax.Quote(arg, syntax.LangBash)
+		if err != nil {
+			q = fmt.Sprintf("%q", arg)
+		}
+		quoted[i] = q
+	}
+	return strings.Join(quoted, " ")
+}
+
+// exitCode returns the exit status carried by err,
+// or -1 if err is not an exit status.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var status interp.ExitStatus
+	if errors.As(err, &status) {
+		return int(status)
+	}
+	return -1
+}
@@ -0,0 +1,73 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_Trace(t *testing.T) {
+	script := Script{
+		Content: "echo hello\nfalse\n'true' 'a b'\nsh -c 'exit 3' || true",
+		Name:    "install.sh",
+	}
+
+	var trace bytes.Buffer
+	logger := log.New("test")
+	opts := Options{Trace: true, TraceWriter: &trace}
+
+	_, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, opts, logger)
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+
+	output := trace.String()
+	for _, want := range []string{
+		"+ install.sh:1: echo hello\n",
+		"+ install.sh:2: false\n",
+		"+ install.sh:3: true 'a b'\n",
+		"+ install.sh:4: sh -c 'exit 3'\n",
+		"- install.sh:4: sh exited 3 after ",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("trace output missing %q:\n%s", want, output)
+		}
+	}
+	// Builtins have no exit line.
+	if strings.Contains(output, "- install.sh:1:") {
+		t.Errorf("trace output has an exit line for a builtin:\n%s", output)
+	}
+}
+
+func TestRunWithOptions_TraceLogger(t *testing.T) {
+	script := Script{
+		Content: "echo traced",
+		Name:    "test.sh",
+	}
+
+	var buf bytes.Buffer
+	logger := log.New("test")
+	logger.SetOutput(&buf)
+	logger.SetDebug(true)
+
+	_, err := RunWithOptions(context.Background(), script, nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{Trace: true}, logger)
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "+ test.sh:1: echo traced") {
+		t.Errorf("debug output should contain trace line: %q", buf.String())
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if got := exitCode(nil); got != 0 {
+		t.Errorf("exitCode(nil) = %d, want 0", got)
+	}
+	if got := exitCode(context.Canceled); got != -1 {
+		t.Errorf("exitCode(context.Canceled) = %d, want -1", got)
+	}
+}
@@ -0,0 +1,312 @@
+package shell
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// ChangeKind describes how a file changed.
+type ChangeKind string
+
+const (
+	ChangeCreated  ChangeKind = "created"
+	ChangeModified ChangeKind = "modified"
+	ChangeDeleted  ChangeKind = "deleted"
+)
+
+// FileChange describes a file a script created, modified or deleted.
+// Hashes are hex SHA-256 digests of regular files.
+type FileChange struct {
+	Path string
+	Kind ChangeKind
+
+	// Mode, Hash and Link describe the file after the run, and PrevMode,
+	// PrevHash and PrevLink before it. Link is a symlink's target.
+	Mode     fs.FileMode
+	Hash     string
+	Link     string
+	PrevMode fs.FileMode
+	PrevHash string
+	PrevLink string
+
+	// Backup is a copy of the previous content of a modified or deleted
+	// regular file, if Options.BackupDir was set.
+	Backup string
+}
+
+// fileState is what is compared to detect changes to a file.
+type fileState struct {
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+	hash    string
+	link    string
+}
+
+// statFile returns the state of path, or false if it does not exist.
+// Regular files are hashed unless their metadata matches prev.
+func statFile(path string, prev *fileState) (*fileState, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, false
+	}
+	st := &fileState{mode: info.Mode(), size: info.Size(), modTime: info.ModTime()}
+	switch {
+	case info.Mode().IsRegular():
+		if prev != nil && prev.mode == st.mode && prev.size == st.size && prev.modTime.Equal(st.modTime) {
+			st.hash = prev.hash
+		} else {
+			st.hash = hashFile(path)
+		}
+	case info.Mode()&fs.ModeSymlink != 0:
+		st.link, _ = os.Readlink(path)
+	}
+	return st, true
+}
+
+func hashFile(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// changeTracker records the files a script changes: those the
+// interpreter and in-process commands write, and those anywhere under
+// roots, which are scanned before and after the run to catch changes made
+// by external commands.
+type changeTracker struct {
+	roots     []string
+	backupDir string
+
+	mu      sync.Mutex
+	before  map[string]*fileState // nil state: did not exist
+	backups map[string]bool       // backups made during this run, by hash
+	trees   []string              // written by in-process commands
+}
+
+func newChangeTracker(roots []string, backupDir string) (*changeTracker, error) {
+	t := &changeTracker{}
+	if backupDir != "" {
+		abs, err := filepath.Abs(backupDir)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(abs, 0o700); err != nil {
+			return nil, err
+		}
+		t.backupDir = abs
+	}
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		t.roots = append(t.roots, abs)
+	}
+	return t, nil
+}
+
+// start records the state of the roots before a run.
+func (t *changeTracker) start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.before = map[string]*fileState{}
+	t.backups = map[string]bool{}
+	t.trees = nil
+	for _, root := range t.roots {
+		t.scan(root, func(path string) {
+			t.before[path], _ = statFile(path, nil)
+			t.backup(path, t.before[path])
+		})
+	}
+}
+
+// backup copies a regular file to the backup directory, named by its
+// hash so that identical files share a backup.
+func (t *changeTracker) backup(path string, st *fileState) {
+	if t.backupDir == "" || st == nil || st.hash == "" {
+		return
+	}
+	dst := filepath.Join(t.backupDir, st.hash)
+	if _, err := os.Lstat(dst); err == nil {
+		return
+	}
+	if err := copyFile(path, dst, 0o600); err == nil {
+		t.backups[st.hash] = true
+	}
+}
+
+// backupPath returns the backup of a file's previous content, if any.
+func (t *changeTracker) backupPath(hash string) string {
+	if t.backupDir == "" || hash == "" {
+		return ""
+	}
+	dst := filepath.Join(t.backupDir, hash)
+	if _, err := os.Lstat(dst); err != nil {
+		return ""
+	}
+	return dst
+}
+
+// scan calls fn for root and every path under it.
+func (t *changeTracker) scan(root string, fn func(path string)) {
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil && d == nil {
+			return nil
+		}
+		fn(path)
+		return nil
+	})
+}
+
+// open records the state of files before the interpreter writes them.
+func (t *changeTracker) open(next interp.OpenHandlerFunc) interp.OpenHandlerFunc {
+	return func(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+		if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 && path != "" {
+			abs := path
+			if !filepath.IsAbs(abs) {
+				abs = filepath.Join(interp.HandlerCtx(ctx).Dir, abs)
+			}
+			t.track(filepath.Clean(abs))
+		}
+		return next(ctx, path, flag, perm)
+	}
+}
+
+// trackerKey is the context key under which in-process commands find
+// the tracker.
+type trackerKey struct{}
+
+// trackWrite records the state of path and everything under it before an
+// in-process command writes it, if changes are tracked. Missing parent
+// directories, which the command may create, are included.
+func trackWrite(ctx context.Context, path string) {
+	if t, ok := ctx.Value(trackerKey{}).(*changeTracker); ok {
+		t.trackTree(filepath.Clean(path))
+	}
+}
+
+// trackTree tracks the tree rooted at path, or at its topmost missing
+// ancestor, and scans it again when the run ends.
+func (t *changeTracker) trackTree(path string) {
+	if isDevice(path) {
+		return
+	}
+	for {
+		parent := filepath.Dir(path)
+		if _, err := os.Lstat(parent); err == nil || parent == path {
+			break
+		}
+		path = parent
+	}
+	t.track(path)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.before == nil {
+		return
+	}
+	t.trees = append(t.trees, path)
+	t.scan(path, func(p string) {
+		if _, ok := t.before[p]; !ok {
+			t.before[p], _ = statFile(p, nil)
+			t.backup(p, t.before[p])
+		}
+	})
+}
+
+func (t *changeTracker) track(path string) {
+	if isDevice(path) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.before[path]; ok || t.before == nil {
+		return
+	}
+	t.before[path], _ = statFile(path, nil)
+	t.backup(path, t.before[path])
+}
+
+func isDevice(path string) bool {
+	return path == "/dev" || strings.HasPrefix(path, "/dev/")
+}
+
+// changes compares the tracked files with their state before the run.
+func (t *changeTracker) changes() []FileChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	before := t.before
+	t.before = nil
+
+	after := map[string]*fileState{}
+	for path, prev := range before {
+		after[path], _ = statFile(path, prev)
+	}
+	for _, root := range slices.Concat(t.roots, t.trees) {
+		t.scan(root, func(path string) {
+			if _, ok := after[path]; !ok {
+				after[path], _ = statFile(path, nil)
+			}
+		})
+	}
+
+	var changes []FileChange
+	for path, cur := range after {
+		prev := before[path]
+		c := FileChange{Path: path}
+		switch {
+		case prev == nil && cur == nil:
+			continue
+		case prev == nil:
+			c.Kind = ChangeCreated
+		case cur == nil:
+			c.Kind = ChangeDeleted
+		case prev.mode != cur.mode || prev.hash != cur.hash || prev.link != cur.link:
+			c.Kind = ChangeModified
+		default:
+			continue
+		}
+		if cur != nil {
+			c.Mode, c.Hash, c.Link = cur.mode, cur.hash, cur.link
+		}
+		if prev != nil {
+			c.PrevMode, c.PrevHash, c.PrevLink = prev.mode, prev.hash, prev.link
+			c.Backup = t.backupPath(prev.hash)
+		}
+		changes = append(changes, c)
+	}
+
+	// Keep only the backups of files that changed.
+	needed := map[string]bool{}
+	for _, c := range changes {
+		needed[c.PrevHash] = true
+	}
+	for hash := range t.backups {
+		if !needed[hash] {
+			_ = os.Remove(filepath.Join(t.backupDir, hash))
+		}
+	}
+	t.backups = nil
+	t.trees = nil
+
+	slices.SortFunc(changes, func(a, b FileChange) int { return strings.Compare(a.Path, b.Path) })
+	return changes
+}
@@ -0,0 +1,130 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+func TestRunWithOptions_TrackChanges(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{"keep.txt": "keep\n", "mod.txt": "old\n", "del.txt": "del\n"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outside := filepath.Join(t.TempDir(), "out.txt")
+
+	script := Script{Name: "install.sh", Content: `
+echo new > "$1/new.txt"
+sh -c 'echo changed > "$1/mod.txt"' sh "$1"
+rm "$1/del.txt"
+mkdir "$1/bin"
+ln -s ../new.txt "$1/bin/link"
+touch "$1/keep.txt"
+echo out > "$2"`}
+
+	opts := Options{TrackChanges: true, TrackRoots: []string{root}}
+	result, err := RunWithOptions(context.Background(), script, []string{root, outside}, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, opts, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	want := map[string]FileChange{
+		filepath.Join(root, "bin"):      {Kind: ChangeCreated},
+		filepath.Join(root, "bin/link"): {Kind: ChangeCreated, Link: "../new.txt"},
+		filepath.Join(root, "del.txt"):  {Kind: ChangeDeleted, PrevHash: sum("del\n")},
+		filepath.Join(root, "mod.txt"):  {Kind: ChangeModified, Hash: sum("changed\n"), PrevHash: sum("old\n")},
+		filepath.Join(root, "new.txt"):  {Kind: ChangeCreated, Hash: sum("new\n")},
+		outside:                         {Kind: ChangeCreated, Hash: sum("out\n")},
+	}
+
+	if len(result.Changes) != len(want) {
+		t.Errorf("Changes = %+v, want %d changes", result.Changes, len(want))
+	}
+	for i, c := range result.Changes {
+		if i > 0 && result.Changes[i-1].Path >= c.Path {
+			t.Errorf("Changes not sorted at %s", c.Path)
+		}
+		w, ok := want[c.Path]
+		if !ok {
+			t.Errorf("unexpected change %+v", c)
+			continue
+		}
+		if c.Kind != w.Kind || c.Hash != w.Hash || c.PrevHash != w.PrevHash || c.Link != w.Link {
+			t.Errorf("change = %+v, want %+v", c, w)
+		}
+	}
+	for _, c := range result.Changes {
+		switch c.Kind {
+		case ChangeDeleted:
+			if c.PrevMode.Perm() != 0o644 {
+				t.Errorf("%s PrevMode = %v", c.Path, c.PrevMode)
+			}
+		case ChangeCreated:
+			if strings.HasSuffix(c.Path, "bin") && !c.Mode.IsDir() {
+				t.Errorf("%s Mode = %v, want directory", c.Path, c.Mode)
+			}
+		}
+	}
+}
+
+func TestRunWithOptions_TrackChangesCoreutils(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "old.txt"), []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// No roots are scanned, so only the writes reported by the in-process
+	// commands are found.
+	script := Script{Name: "install.sh", Content: `
+mkdir -p "$1/a/b"
+touch "$1/a/b/f"
+mv "$1/old.txt" "$1/a/moved.txt"
+sed -i 's/old/new/' "$1/a/moved.txt"`}
+	opts := Options{Coreutils: true, TrackChanges: true}
+	result, err := RunWithOptions(context.Background(), script, []string{dir}, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, opts, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+
+	want := map[string]ChangeKind{
+		filepath.Join(dir, "a"):           ChangeCreated,
+		filepath.Join(dir, "a/b"):         ChangeCreated,
+		filepath.Join(dir, "a/b/f"):       ChangeCreated,
+		filepath.Join(dir, "a/moved.txt"): ChangeCreated,
+		filepath.Join(dir, "old.txt"):     ChangeDeleted,
+	}
+	got := map[string]ChangeKind{}
+	for _, c := range result.Changes {
+		got[c.Path] = c.Kind
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("Changes = %v, want %v", got, want)
+	}
+}
+
+func TestRunWithOptions_TrackChangesDisabled(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.txt")
+	script := Script{Name: "test.sh", Content: `echo out > "$1"`}
+	result, err := RunWithOptions(context.Background(), script, []string{out}, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, Options{}, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	if result.Changes != nil {
+		t.Errorf("Changes = %+v, want nil", result.Changes)
+	}
+}
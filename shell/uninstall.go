@@ -0,0 +1,254 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// profileFiles are the shell startup files installers commonly edit to
+// extend PATH. Lines added to them are removed on uninstall rather than
+// restoring the whole file, keeping the user's later edits.
+var profileFiles = map[string]bool{
+	".profile":      true,
+	".bash_profile": true,
+	".bash_login":   true,
+	".bashrc":       true,
+	".zshenv":       true,
+	".zprofile":     true,
+	".zshrc":        true,
+	".kshrc":        true,
+	".mkshrc":       true,
+	"config.fish":   true,
+}
+
+// Uninstall reverts the file changes made by an install. It can be
+// applied directly or rendered as a shell script, and marshals to JSON
+// as a manifest.
+type Uninstall struct {
+	// Remove lists the files and directories to remove, files first and
+	// directories deepest first. Directories are only removed if empty.
+	Remove []RemovePath `json:"remove,omitempty"`
+
+	// Restore lists the files to put back as they were.
+	Restore []RestorePath `json:"restore,omitempty"`
+
+	// ProfileEdits lists lines to remove from shell profile files.
+	ProfileEdits []ProfileEdit `json:"profile_edits,omitempty"`
+
+	// Unrestorable lists modified or deleted files with no backup.
+	Unrestorable []string `json:"unrestorable,omitempty"`
+}
+
+// RemovePath is a created file or directory.
+type RemovePath struct {
+	Path string `json:"path"`
+	Dir  bool   `json:"dir,omitempty"`
+}
+
+// RestorePath is a modified or deleted file, restored from Backup, or as
+// a directory or a symlink to Link.
+type RestorePath struct {
+	Path   string      `json:"path"`
+	Mode   fs.FileMode `json:"mode"`
+	Backup string      `json:"backup,omitempty"`
+	Link   string      `json:"link,omitempty"`
+}
+
+// ProfileEdit is a profile file with the lines the install added.
+type ProfileEdit struct {
+	Path  string   `json:"path"`
+	Lines []string `json:"lines"`
+}
+
+// NewUninstall plans how to revert changes, as returned in
+// Result.Changes with Options.BackupDir set. It must be called before
+// the changed files are edited further, as it compares profile files
+// with their backups.
+func NewUninstall(changes []FileChange) (*Uninstall, error) {
+	u := &Uninstall{}
+	var dirs []RemovePath
+	for _, c := range changes {
+		switch c.Kind {
+		case ChangeCreated:
+			if c.Mode.IsDir() {
+				dirs = append(dirs, RemovePath{Path: c.Path, Dir: true})
+			} else {
+				u.Remove = append(u.Remove, RemovePath{Path: c.Path})
+			}
+			continue
+		case ChangeModified:
+			if profileFiles[filepath.Base(c.Path)] && c.Backup != "" && c.Mode.IsRegular() {
+				lines, err := addedLines(c.Backup, c.Path)
+				if err != nil {
+					return nil, err
+				}
+				if len(lines) > 0 {
+					u.ProfileEdits = append(u.ProfileEdits, ProfileEdit{Path: c.Path, Lines: lines})
+				}
+				continue
+			}
+		}
+
+		r := RestorePath{Path: c.Path, Mode: c.PrevMode}
+		switch {
+		case c.PrevMode.IsDir():
+		case c.PrevMode&fs.ModeSymlink != 0:
+			r.Link = c.PrevLink
+		case c.PrevMode.IsRegular() && c.Backup != "":
+			r.Backup = c.Backup
+		default:
+			u.Unrestorable = append(u.Unrestorable, c.Path)
+			continue
+		}
+		u.Restore = append(u.Restore, r)
+	}
+
+	// Deepest directories first, so that they are empty when removed.
+	slices.SortFunc(dirs, func(a, b RemovePath) int { return strings.Compare(b.Path, a.Path) })
+	u.Remove = append(u.Remove, dirs...)
+	return u, nil
+}
+
+// addedLines returns the lines of path that were not in backup.
+func addedLines(backup, path string) ([]string, error) {
+	old, err := os.ReadFile(backup)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	for _, line := range splitLines(old) {
+		existing[line] = true
+	}
+	var added []string
+	for _, line := range splitLines(cur) {
+		if !existing[line] && strings.TrimSpace(line) != "" && !slices.Contains(added, line) {
+			added = append(added, line)
+		}
+	}
+	return added, nil
+}
+
+func splitLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+// Apply reverts the changes. It carries on after errors, returning them
+// all.
+func (u *Uninstall) Apply() error {
+	var errs []error
+	for _, r := range u.Remove {
+		err := os.Remove(r.Path)
+		switch {
+		case err == nil, errors.Is(err, fs.ErrNotExist):
+		case r.Dir:
+			// Not empty: it has files the install did not create.
+		default:
+			errs = append(errs, err)
+		}
+	}
+	for _, r := range u.Restore {
+		if err := r.apply(); err != nil {
+			errs = append(errs, fmt.Errorf("restore %s: %w", r.Path, err))
+		}
+	}
+	for _, e := range u.ProfileEdits {
+		if err := e.apply(); err != nil {
+			errs = append(errs, fmt.Errorf("edit %s: %w", e.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r RestorePath) apply() error {
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0o755); err != nil {
+		return err
+	}
+	switch {
+	case r.Mode.IsDir():
+		if err := os.MkdirAll(r.Path, r.Mode.Perm()); err != nil {
+			return err
+		}
+	case r.Link != "":
+		_ = os.Remove(r.Path)
+		return os.Symlink(r.Link, r.Path)
+	default:
+		_ = os.Remove(r.Path)
+		if err := copyFile(r.Backup, r.Path, r.Mode); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(r.Path, r.Mode.Perm())
+}
+
+func (e ProfileEdit) apply() error {
+	data, err := os.ReadFile(e.Path)
+	if err != nil {
+		return err
+	}
+	var kept bytes.Buffer
+	for _, line := range splitLines(data) {
+		if !slices.Contains(e.Lines, line) {
+			kept.WriteString(line + "\n")
+		}
+	}
+	return os.WriteFile(e.Path, kept.Bytes(), 0o644)
+}
+
+// Script renders the uninstall as a POSIX shell script.
+func (u *Uninstall) Script() string {
+	var sb strings.Builder
+	sb.WriteString("#!/bin/sh\n# Reverts the changes made by an install.\n")
+	for _, r := range u.Remove {
+		if r.Dir {
+			fmt.Fprintf(&sb, "rmdir -- %s 2>/dev/null\n", shellQuote(r.Path))
+		} else {
+			fmt.Fprintf(&sb, "rm -f -- %s\n", shellQuote(r.Path))
+		}
+	}
+	for _, r := range u.Restore {
+		path := shellQuote(r.Path)
+		fmt.Fprintf(&sb, "mkdir -p -- %s\n", shellQuote(filepath.Dir(r.Path)))
+		switch {
+		case r.Mode.IsDir():
+			fmt.Fprintf(&sb, "mkdir -p -- %s\n", path)
+		case r.Link != "":
+			fmt.Fprintf(&sb, "rm -f -- %s && ln -s -- %s %s\n", path, shellQuote(r.Link), path)
+			continue
+		default:
+			fmt.Fprintf(&sb, "rm -f -- %s && cp -- %s %s\n", path, shellQuote(r.Backup), path)
+		}
+		fmt.Fprintf(&sb, "chmod %o %s\n", r.Mode.Perm(), path)
+	}
+	for _, e := range u.ProfileEdits {
+		fmt.Fprintf(&sb, "# Remove lines added to %s\n", e.Path)
+		// grep exits with 1 if no lines are kept, and 2 on errors, when
+		// the profile must be left alone.
+		sb.WriteString("tmp=$(mktemp) && {\n\tgrep -v -x -F")
+		for _, line := range e.Lines {
+			fmt.Fprintf(&sb, " -e %s", shellQuote(line))
+		}
+		fmt.Fprintf(&sb, " -- %s >\"$tmp\"\n", shellQuote(e.Path))
+		fmt.Fprintf(&sb, "\tif [ $? -le 1 ]; then cat \"$tmp\" >%s; fi\n", shellQuote(e.Path))
+		sb.WriteString("\trm -f \"$tmp\"\n}\n")
+	}
+	for _, path := range u.Unrestorable {
+		fmt.Fprintf(&sb, "# Cannot restore %s: no backup\n", path)
+	}
+	return sb.String()
+}
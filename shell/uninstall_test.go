@@ -0,0 +1,150 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/installable-sh/lib/log"
+)
+
+// install runs an installer against a fake home directory and returns
+// its uninstall plan.
+func install(t *testing.T) (home string, u *Uninstall) {
+	t.Helper()
+	home = t.TempDir()
+	files := map[string]string{
+		".profile":        "# user profile\nexport EDITOR=vi\n",
+		"etc/tool.conf":   "old config\n",
+		"etc/legacy.conf": "legacy\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(home, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	script := Script{Name: "install.sh", Content: `
+mkdir -p "$1/.tool/bin"
+echo '#!/bin/sh' > "$1/.tool/bin/tool"
+chmod 755 "$1/.tool/bin/tool"
+echo 'export PATH="$HOME/.tool/bin:$PATH"' >> "$1/.profile"
+echo "new config" > "$1/etc/tool.conf"
+rm "$1/etc/legacy.conf"`}
+	opts := Options{TrackChanges: true, TrackRoots: []string{home}, BackupDir: t.TempDir()}
+	result, err := RunWithOptions(context.Background(), script, []string{home}, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, opts, log.New("test"))
+	if err != nil {
+		t.Fatalf("RunWithOptions() error: %v", err)
+	}
+	u, err = NewUninstall(result.Changes)
+	if err != nil {
+		t.Fatalf("NewUninstall() error: %v", err)
+	}
+	return home, u
+}
+
+func TestNewUninstall(t *testing.T) {
+	home, u := install(t)
+
+	wantRemove := []RemovePath{
+		{Path: filepath.Join(home, ".tool/bin/tool")},
+		{Path: filepath.Join(home, ".tool/bin"), Dir: true},
+		{Path: filepath.Join(home, ".tool"), Dir: true},
+	}
+	if !slices.Equal(u.Remove, wantRemove) {
+		t.Errorf("Remove = %+v, want %+v", u.Remove, wantRemove)
+	}
+	if len(u.Restore) != 2 {
+		t.Errorf("Restore = %+v, want 2 files", u.Restore)
+	}
+	wantEdits := []ProfileEdit{{Path: filepath.Join(home, ".profile"), Lines: []string{`export PATH="$HOME/.tool/bin:$PATH"`}}}
+	if len(u.ProfileEdits) != 1 || u.ProfileEdits[0].Path != wantEdits[0].Path || !slices.Equal(u.ProfileEdits[0].Lines, wantEdits[0].Lines) {
+		t.Errorf("ProfileEdits = %+v, want %+v", u.ProfileEdits, wantEdits)
+	}
+	if len(u.Unrestorable) != 0 {
+		t.Errorf("Unrestorable = %v", u.Unrestorable)
+	}
+}
+
+// checkUninstalled verifies home is back to how install found it, apart
+// from later edits to the profile.
+func checkUninstalled(t *testing.T, home string) {
+	t.Helper()
+	if _, err := os.Stat(filepath.Join(home, ".tool")); !os.IsNotExist(err) {
+		t.Errorf(".tool still exists: %v", err)
+	}
+	for name, want := range map[string]string{
+		".profile":        "# user profile\nexport EDITOR=vi\nalias ll='ls -l'\n",
+		"etc/tool.conf":   "old config\n",
+		"etc/legacy.conf": "legacy\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(home, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestUninstall_Apply(t *testing.T) {
+	home, u := install(t)
+	appendProfile(t, home)
+	if err := u.Apply(); err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	checkUninstalled(t, home)
+}
+
+func TestUninstall_Script(t *testing.T) {
+	home, u := install(t)
+	appendProfile(t, home)
+	out, err := exec.Command("sh", "-c", u.Script()).CombinedOutput()
+	if err != nil {
+		t.Fatalf("uninstall script error: %v\n%s\n%s", err, out, u.Script())
+	}
+	checkUninstalled(t, home)
+}
+
+func TestUninstall_ScriptGrepError(t *testing.T) {
+	home, u := install(t)
+	profile := filepath.Join(home, ".profile")
+	before, err := os.ReadFile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A grep that fails midway must not truncate the profile.
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "grep"), []byte("#!/bin/sh\necho partial\nexit 2\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", u.Script())
+	cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("uninstall script error: %v\n%s", err, out)
+	}
+	if after, err := os.ReadFile(profile); err != nil || string(after) != string(before) {
+		t.Errorf("profile = %q, %v, want %q", after, err, before)
+	}
+}
+
+// appendProfile simulates the user editing their profile after install.
+func appendProfile(t *testing.T, home string) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(home, ".profile"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := f.WriteString("alias ll='ls -l'\n"); err != nil {
+		t.Fatal(err)
+	}
+}